@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package seqlock
+
+// 使用 -race 编译时，默认使用Safe模式
+const raceEnabled = true
@@ -0,0 +1,212 @@
+// Package seqlock 提供顺序锁（seqlock），适合保护小的、读多写少的值，比如配置。
+//
+// writer在修改前后各把序号加1，修改期间序号是奇数；reader读之前和读之后各读一次序号，
+// 两次相同并且是偶数，说明读的过程中没有writer，否则重新读。reader不写任何共享内存，
+// 所以不会像RWMutex的readerCount那样在CPU之间来回传递缓存行，也不像atomic.Value
+// 那样每次Store都要分配一个新的值。
+//
+// 按照Go的内存模型，reader直接拷贝正在被writer修改的值是数据竞争，虽然读到的
+// 不一致的值会被丢弃，race detector还是会报告。所以有两种模式：
+//
+//   - Fast 直接拷贝，速度最快，使用 -race 测试时会被报告。只能用于不包含指针的T
+//   - Safe 用原子操作逐个字拷贝，race detector不会报告。T包含指针时不能这样拷贝
+//     （会绕过GC的写屏障），退化为写时复制：每次写分配一个新的值，原子地替换指针
+//
+// T包含指针时（比如有string、slice字段的配置）总是使用写时复制：直接拷贝可能把
+// 一次写的指针和另一次写的长度拼在一起，即使随后会重试，构造出这样的值本身就不安全。
+// 不包含指针的T默认使用Fast模式，使用 -race 编译时默认使用Safe模式。
+package seqlock
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Mode 读写值的方式
+type Mode int
+
+const (
+	Fast Mode = iota // 直接拷贝
+	Safe             // 原子地逐字拷贝，或者写时复制
+)
+
+func (m Mode) String() string {
+	if m == Safe {
+		return "safe"
+	}
+	return "fast"
+}
+
+type config struct {
+	mode Mode
+}
+
+// Option SeqLock的配置项
+type Option func(*config)
+
+// WithMode 设置读写值的方式。T包含指针时只能使用Safe。
+func WithMode(mode Mode) Option {
+	return func(c *config) {
+		c.mode = mode
+	}
+}
+
+// SeqLock 保护一个T类型的值，必须使用New创建
+type SeqLock[T any] struct {
+	seq  uint64 // 奇数表示writer正在修改
+	mode Mode
+
+	val   T              // Fast模式下的值
+	words []uint64       // Safe模式下的值，T不包含指针时按字存储
+	ptr   unsafe.Pointer // Safe模式下的值，T包含指针时指向一个不会再修改的T
+	cow   bool           // 是否使用写时复制
+
+	mu  sync.Mutex // writer之间的互斥
+	cur T          // writer看到的当前值，只在持有mu时访问
+}
+
+// New 创建一个SeqLock，初始值为v。T包含指针时指定Fast模式会panic。
+func New[T any](v T, opts ...Option) *SeqLock[T] {
+	pointers := hasPointers(reflect.TypeOf(&v).Elem())
+	c := config{mode: Fast}
+	if raceEnabled || pointers {
+		c.mode = Safe
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	s := &SeqLock[T]{mode: c.mode}
+	switch {
+	case s.mode == Fast && pointers:
+		panic("seqlock: Fast mode requires a type without pointers")
+	case s.mode == Safe && pointers:
+		s.cow = true
+	case s.mode == Safe:
+		s.words = make([]uint64, (unsafe.Sizeof(v)+7)/8)
+	}
+	s.cur = v
+	s.publish()
+	return s
+}
+
+// Mode 返回读写值的方式
+func (s *SeqLock[T]) Mode() Mode {
+	return s.mode
+}
+
+// Load 读取当前值，读的过程中有writer修改时重试
+func (s *SeqLock[T]) Load() T {
+	v, _ := s.LoadSeq()
+	return v
+}
+
+// LoadSeq 读取当前值和它的序号，序号变了说明值被修改过
+func (s *SeqLock[T]) LoadSeq() (T, uint64) {
+	for i := 0; ; i++ {
+		seq := atomic.LoadUint64(&s.seq)
+		if seq&1 != 0 { // writer正在修改
+			if i >= 16 {
+				runtime.Gosched()
+			}
+			continue
+		}
+		var v T
+		var p unsafe.Pointer
+		switch {
+		case s.cow: // 指向的T不会再修改，确认它和seq是配对的之后再复制
+			p = atomic.LoadPointer(&s.ptr)
+		case s.words != nil:
+			v = loadWords[T](s.words)
+		default:
+			v = s.val
+		}
+		if atomic.LoadUint64(&s.seq) == seq {
+			if p != nil {
+				v = *(*T)(p)
+			}
+			return v, seq
+		}
+	}
+}
+
+// Seq 当前的序号，每次写加2
+func (s *SeqLock[T]) Seq() uint64 {
+	return atomic.LoadUint64(&s.seq) &^ 1
+}
+
+// Store 设置新的值
+func (s *SeqLock[T]) Store(v T) {
+	s.mu.Lock()
+	s.cur = v
+	s.publish()
+	s.mu.Unlock()
+}
+
+// Update 在writer的互斥下修改当前值，fn不能保留这个指针
+func (s *SeqLock[T]) Update(fn func(v *T)) {
+	s.mu.Lock()
+	fn(&s.cur)
+	s.publish()
+	s.mu.Unlock()
+}
+
+// publish 把cur发布给reader，需要持有mu
+func (s *SeqLock[T]) publish() {
+	var p *T
+	if s.cow { // 在加序号之前复制好，reader重试的窗口只有一次指针写入
+		p = new(T)
+		*p = s.cur
+	}
+	atomic.AddUint64(&s.seq, 1)
+	switch {
+	case s.cow:
+		atomic.StorePointer(&s.ptr, unsafe.Pointer(p))
+	case s.words != nil:
+		storeWords(s.words, &s.cur)
+	default:
+		s.val = s.cur
+	}
+	atomic.AddUint64(&s.seq, 1)
+}
+
+// loadWords 用原子操作从words逐字读出一个T
+func loadWords[T any](words []uint64) T {
+	var v T
+	dst := unsafe.Slice((*byte)(unsafe.Pointer(&v)), unsafe.Sizeof(v))
+	for i := range words {
+		w := atomic.LoadUint64(&words[i])
+		copy(dst[i*8:], (*[8]byte)(unsafe.Pointer(&w))[:])
+	}
+	return v
+}
+
+// storeWords 用原子操作把v逐字写入words
+func storeWords[T any](words []uint64, v *T) {
+	src := unsafe.Slice((*byte)(unsafe.Pointer(v)), unsafe.Sizeof(*v))
+	for i := range words {
+		var w uint64
+		copy((*[8]byte)(unsafe.Pointer(&w))[:], src[i*8:])
+		atomic.StoreUint64(&words[i], w)
+	}
+}
+
+// hasPointers 类型t的值是否包含指针
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.UnsafePointer, reflect.Map, reflect.Chan, reflect.Func,
+		reflect.Interface, reflect.Slice, reflect.String:
+		return true
+	case reflect.Array:
+		return t.Len() > 0 && hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,243 @@
+package seqlock
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// pair 两个字段需要一起修改，reader读到的值必须满足A+B==0
+type pair struct {
+	A, B int64
+	C    int32 // 让大小不是8的倍数
+}
+
+// Config 和Atomic/value.go中的Config一样，包含指针
+type Config struct {
+	NodeName string
+	Addr     string
+	Count    int32
+}
+
+func loadNewConfig() Config {
+	return Config{
+		NodeName: "北京",
+		Addr:     "10.77.95.27",
+		Count:    rand.Int31(),
+	}
+}
+
+func modes() []Mode {
+	if raceEnabled { // Fast模式一定会被race detector报告
+		return []Mode{Safe}
+	}
+	return []Mode{Fast, Safe}
+}
+
+func TestConsistent(t *testing.T) {
+	for _, mode := range modes() {
+		t.Run(mode.String(), func(t *testing.T) {
+			s := New(pair{}, WithMode(mode))
+			stop := make(chan struct{})
+			writerDone := make(chan struct{})
+			go func() {
+				defer close(writerDone)
+				for i := int64(1); ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					s.Store(pair{A: i, B: -i, C: int32(i)})
+				}
+			}()
+			var wg sync.WaitGroup
+			for r := 0; r < 4; r++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10000; j++ {
+						v := s.Load()
+						if v.A+v.B != 0 || int64(v.C) != v.A {
+							t.Errorf("inconsistent read %+v", v)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			close(stop)
+			<-writerDone
+		})
+	}
+}
+
+// Config包含指针，默认就是写时复制
+func TestConfig(t *testing.T) {
+	s := New(loadNewConfig())
+	if s.Mode() != Safe || !s.cow {
+		t.Fatalf("Config contains pointers, expect copy-on-write but got %v", s.Mode())
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			s.Store(loadNewConfig())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if c := s.Load(); c.NodeName != "北京" || c.Addr != "10.77.95.27" {
+				t.Errorf("unexpected config %+v", c)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestFastRequiresNoPointers(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic for Fast mode with pointers")
+		}
+	}()
+	New(loadNewConfig(), WithMode(Fast))
+}
+
+func TestUpdateAndSeq(t *testing.T) {
+	for _, mode := range modes() {
+		s := New(pair{}, WithMode(mode))
+		_, seq := s.LoadSeq()
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					s.Update(func(v *pair) {
+						v.A++
+						v.B--
+					})
+				}
+			}()
+		}
+		wg.Wait()
+		v, seq2 := s.LoadSeq()
+		if v.A != 1000 || v.B != -1000 {
+			t.Fatalf("%v: expect {1000 -1000} but got %+v", mode, v)
+		}
+		if seq2-seq != 2000 || s.Seq() != seq2 {
+			t.Fatalf("%v: expect seq to advance by 2000 but got %d -> %d", mode, seq, seq2)
+		}
+	}
+}
+
+// LoadSeq返回的值和序号必须是配对的：第n次Store之后序号是初始序号加2n
+func TestLoadSeqPairing(t *testing.T) {
+	type counted struct {
+		N    uint64
+		Name string // 包含指针，是写时复制
+	}
+	type plain struct {
+		N   uint64
+		Pad [3]uint64
+	}
+	for _, mode := range modes() {
+		s := New(plain{}, WithMode(mode))
+		testPairing(t, mode.String(), s.LoadSeq, func(n uint64) { s.Store(plain{N: n}) }, func(v plain) uint64 { return v.N })
+	}
+	s := New(counted{Name: "0"})
+	testPairing(t, "copy-on-write", s.LoadSeq, func(n uint64) { s.Store(counted{N: n, Name: "n"}) }, func(v counted) uint64 { return v.N })
+}
+
+// testPairing 一个writer不停地写入第n次的值n，检查读到的值和序号是否配对
+func testPairing[T any](t *testing.T, name string, loadSeq func() (T, uint64), store func(n uint64), n func(T) uint64) {
+	_, base := loadSeq()
+	stop := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for i := uint64(1); ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			store(i)
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-writerDone
+	}()
+	for j := 0; j < 100000; j++ {
+		v, seq := loadSeq()
+		if want := (seq - base) / 2; n(v) != want {
+			t.Fatalf("%v: value %d paired with seq %d (expect value %d)", name, n(v), seq, want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	words := make([]uint64, 3)
+	v := pair{A: 1, B: -2, C: 3}
+	storeWords(words, &v)
+	if got := loadWords[pair](words); got != v {
+		t.Fatalf("expect %+v but got %+v", v, got)
+	}
+	if hasPointers(reflect.TypeOf(pair{})) || !hasPointers(reflect.TypeOf(Config{})) {
+		t.Fatal("wrong pointer detection")
+	}
+}
+
+// readBench 一个writer偶尔写的同时，GOMAXPROCS个reader并发读
+func readBench(b *testing.B, load func() Config, store func(Config)) {
+	var stop int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; atomic.LoadInt32(&stop) == 0; i++ {
+			if i%1000 == 0 {
+				store(loadNewConfig())
+			}
+		}
+	}()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = load()
+		}
+	})
+	atomic.StoreInt32(&stop, 1)
+	<-done
+}
+
+// BenchmarkLoad 读配置，比较seqlock、atomic.Value和sync.RWMutex
+func BenchmarkLoad(b *testing.B) {
+	b.Run("seqlock", func(b *testing.B) { // Config包含指针，是写时复制
+		s := New(loadNewConfig())
+		readBench(b, s.Load, s.Store)
+	})
+	b.Run("atomic.Value", func(b *testing.B) {
+		var v atomic.Value
+		v.Store(loadNewConfig())
+		readBench(b, func() Config { return v.Load().(Config) }, func(c Config) { v.Store(c) })
+	})
+	b.Run("sync.RWMutex", func(b *testing.B) {
+		var mu sync.RWMutex
+		c := loadNewConfig()
+		readBench(b, func() Config {
+			mu.RLock()
+			defer mu.RUnlock()
+			return c
+		}, func(n Config) {
+			mu.Lock()
+			c = n
+			mu.Unlock()
+		})
+	})
+}
@@ -0,0 +1,410 @@
+package cmap
+
+import (
+	"container/heap"
+	"container/list"
+	"sort"
+	"sync/atomic"
+)
+
+// EvictionPolicy 有容量限制时选择淘汰哪个元素
+type EvictionPolicy int
+
+const (
+	LRU EvictionPolicy = iota // 淘汰最久没有访问的
+	LFU                       // 淘汰访问次数最少的，次数相同时淘汰最久没有访问的
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case LRU:
+		return "LRU"
+	case LFU:
+		return "LFU"
+	}
+	return "unknown"
+}
+
+// CostFunc 计算元素的代价，和WithMaxCost一起使用
+type CostFunc[K comparable, V any] func(key K, val V) int64
+
+// WithMaxEntries 限制元素的总数量。限制平均分到每个分片，分片满了之后按淘汰策略淘汰它自己的元素，
+// 不需要全局的锁；代价是key分布不均匀时，总数量还没有到上限就可能开始淘汰。
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxEntries = n
+		}
+	}
+}
+
+// WithMaxCost 限制元素的总代价，和WithMaxEntries一样平均分到每个分片。
+// 代价由WithCost设置的函数计算，没有设置时每个元素的代价是1。
+// 代价超过一个分片的上限的元素不会被保存，插入时直接淘汰。
+func WithMaxCost(c int64) Option {
+	return func(o *options) {
+		if c > 0 {
+			o.maxCost = c
+		}
+	}
+}
+
+// WithCost 设置计算元素代价的函数，K和V要和map的类型一致
+func WithCost[K comparable, V any](fn CostFunc[K, V]) Option {
+	return func(o *options) {
+		o.cost = fn
+	}
+}
+
+// WithEvictionPolicy 设置淘汰策略，默认是LRU
+func WithEvictionPolicy(p EvictionPolicy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// counters 命中和淘汰的计数，原子操作。放在结构体的最前面，保证32位平台上64位对齐。
+type counters struct {
+	hits      uint64
+	misses    uint64
+	evictions [numEvictReasons]uint64
+}
+
+func (c *counters) add(o *counters) {
+	atomic.AddUint64(&c.hits, atomic.LoadUint64(&o.hits))
+	atomic.AddUint64(&c.misses, atomic.LoadUint64(&o.misses))
+	for i := range c.evictions {
+		atomic.AddUint64(&c.evictions[i], atomic.LoadUint64(&o.evictions[i]))
+	}
+}
+
+// Stats map的统计信息
+type Stats struct {
+	Hits      uint64                 // Get找到了key，只在有容量限制时统计
+	Misses    uint64                 // Get没有找到key，包括已经过期的，只在有容量限制时统计
+	Evictions map[EvictReason]uint64 // 被自动移除的元素数量
+	Cost      int64                  // 有容量限制时所有元素的总代价
+}
+
+// Stats 返回统计信息。每个分片的计数是独立的，不同分片之间不是同一时刻的值。
+func (m *ConcurrentMap[K, V]) Stats() Stats {
+	var c counters
+	var cost int64
+	// 迁移一个分片时持有resizeMu的写锁，把它的计数移到retired，
+	// 在读锁下读retired和当前的分片，不会漏掉正在迁移的分片
+	m.resizeMu.RLock()
+	c.add(&m.retired)
+	for _, s := range m.shards() {
+		c.add(&s.counters)
+		if s.policy != nil {
+			s.policyMu.Lock()
+			cost += s.policy.cost()
+			s.policyMu.Unlock()
+		}
+	}
+	m.resizeMu.RUnlock()
+
+	st := Stats{Hits: c.hits, Misses: c.misses, Evictions: make(map[EvictReason]uint64), Cost: cost}
+	for i, n := range c.evictions {
+		if n > 0 {
+			st.Evictions[EvictReason(i+1)] = n
+		}
+	}
+	return st
+}
+
+func (m *ConcurrentMap[K, V]) bounded() bool {
+	return m.maxEntries > 0 || m.maxCost > 0
+}
+
+func (m *ConcurrentMap[K, V]) costOf(key K, val V) int64 {
+	if m.cost == nil {
+		return 1
+	}
+	return m.cost(key, val)
+}
+
+// limit 把上限平均分到n个分片，向上取整
+func limit(max int64, n int) int64 {
+	if max <= 0 {
+		return 0
+	}
+	return (max + int64(n) - 1) / int64(n)
+}
+
+// eviction 持有写锁时淘汰的元素，释放锁之后调用回调
+type eviction[K comparable, V any] struct {
+	key    K
+	val    V
+	reason EvictReason
+}
+
+// record 记录一个被淘汰的元素，需要持有写锁
+func (s *Shard[K, V]) record(key K, val V, reason EvictReason) {
+	atomic.AddUint64(&s.evictions[reason-1], 1)
+	s.pending = append(s.pending, eviction[K, V]{key, val, reason})
+}
+
+// unlock 释放分片的写锁，然后调用这期间被淘汰的元素的回调
+func (m *ConcurrentMap[K, V]) unlock(s *Shard[K, V]) {
+	pending := s.pending
+	s.pending = nil
+	s.Unlock()
+	for _, e := range pending {
+		m.evict(e.key, e.val, e.reason)
+	}
+}
+
+// put 设置分片中key的值，有容量限制时更新淘汰记录，超出上限时淘汰元素，需要持有写锁
+func (m *ConcurrentMap[K, V]) put(s *Shard[K, V], key K, val V) {
+	s.items[key] = val
+	if s.policy == nil {
+		return
+	}
+	cost := m.costOf(key, val)
+	s.policyMu.Lock()
+	if s.maxCost > 0 && cost > s.maxCost { // 放不下，直接淘汰它，不影响其它元素
+		s.policy.remove(key)
+		delete(s.items, key)
+		delete(s.expires, key)
+		s.record(key, val, EvictCost)
+	} else {
+		s.policy.set(key, cost)
+		s.shrink(key)
+	}
+	s.policyMu.Unlock()
+}
+
+// del 删除分片中的key，需要持有写锁
+func (s *Shard[K, V]) del(key K) {
+	delete(s.items, key)
+	delete(s.expires, key)
+	if s.policy != nil {
+		s.policyMu.Lock()
+		s.policy.remove(key)
+		s.policyMu.Unlock()
+	}
+}
+
+// touch Get访问了key。Get只持有读锁，访问记录由policyMu保护。
+func (s *Shard[K, V]) touch(key K) {
+	s.policyMu.Lock()
+	s.policy.touch(key)
+	s.policyMu.Unlock()
+}
+
+// shrink 淘汰元素直到不超过分片的上限，尽量不淘汰刚插入的keep。
+// 需要持有写锁和policyMu。
+func (s *Shard[K, V]) shrink(keep K) {
+	for {
+		var reason EvictReason
+		switch {
+		case s.maxEntries > 0 && int64(s.policy.len()) > s.maxEntries:
+			reason = EvictCapacity
+		case s.maxCost > 0 && s.policy.cost() > s.maxCost:
+			reason = EvictCost
+		default:
+			return
+		}
+		key := s.policy.victim(keep)
+		if s.expired(key) { // 反正已经过期了
+			reason = EvictExpired
+		}
+		val := s.items[key]
+		s.policy.remove(key)
+		delete(s.items, key)
+		delete(s.expires, key)
+		s.record(key, val, reason)
+	}
+}
+
+// tracker 一个分片的淘汰记录
+type tracker[K comparable] interface {
+	set(key K, cost int64)                  // 插入key或者更新它的代价，算作一次访问
+	restore(key K, cost int64, freq uint64) // 迁移时插入key，保留访问次数
+	touch(key K)
+	remove(key K)
+	victim(keep K) K // 下一个要淘汰的key，只剩keep的时候才返回keep
+	walk(fn func(key K, freq uint64))
+	len() int
+	cost() int64
+}
+
+func newTracker[K comparable](p EvictionPolicy) tracker[K] {
+	if p == LFU {
+		return &lfu[K]{nodes: make(map[K]*lfuEntry[K])}
+	}
+	return &lru[K]{nodes: make(map[K]*list.Element)}
+}
+
+type lruEntry[K comparable] struct {
+	key  K
+	cost int64
+}
+
+// lru 按访问时间排列的链表，最近访问的在前面
+type lru[K comparable] struct {
+	ll    list.List
+	nodes map[K]*list.Element
+	total int64
+}
+
+func (l *lru[K]) set(key K, cost int64) {
+	if el, ok := l.nodes[key]; ok {
+		e := el.Value.(*lruEntry[K])
+		l.total += cost - e.cost
+		e.cost = cost
+		l.ll.MoveToFront(el)
+		return
+	}
+	l.nodes[key] = l.ll.PushFront(&lruEntry[K]{key, cost})
+	l.total += cost
+}
+
+func (l *lru[K]) restore(key K, cost int64, freq uint64) {
+	l.set(key, cost)
+}
+
+func (l *lru[K]) touch(key K) {
+	if el, ok := l.nodes[key]; ok {
+		l.ll.MoveToFront(el)
+	}
+}
+
+func (l *lru[K]) remove(key K) {
+	if el, ok := l.nodes[key]; ok {
+		l.total -= el.Value.(*lruEntry[K]).cost
+		l.ll.Remove(el)
+		delete(l.nodes, key)
+	}
+}
+
+func (l *lru[K]) victim(keep K) K {
+	el := l.ll.Back()
+	if e := el.Value.(*lruEntry[K]); e.key == keep && el.Prev() != nil {
+		el = el.Prev()
+	}
+	return el.Value.(*lruEntry[K]).key
+}
+
+// walk 从最久没有访问的开始
+func (l *lru[K]) walk(fn func(key K, freq uint64)) {
+	for el := l.ll.Back(); el != nil; el = el.Prev() {
+		fn(el.Value.(*lruEntry[K]).key, 0)
+	}
+}
+
+func (l *lru[K]) len() int    { return len(l.nodes) }
+func (l *lru[K]) cost() int64 { return l.total }
+
+type lfuEntry[K comparable] struct {
+	key   K
+	cost  int64
+	freq  uint64
+	tick  uint64 // 最后一次访问的时间
+	index int
+}
+
+// lfu 按(访问次数, 最后访问时间)排列的最小堆
+type lfu[K comparable] struct {
+	h     lfuHeap[K]
+	nodes map[K]*lfuEntry[K]
+	tick  uint64
+	total int64
+}
+
+func (l *lfu[K]) set(key K, cost int64) {
+	if e, ok := l.nodes[key]; ok {
+		l.total += cost - e.cost
+		e.cost = cost
+		l.touch(key)
+		return
+	}
+	l.restore(key, cost, 1)
+}
+
+func (l *lfu[K]) restore(key K, cost int64, freq uint64) {
+	if freq == 0 {
+		freq = 1
+	}
+	l.tick++
+	e := &lfuEntry[K]{key: key, cost: cost, freq: freq, tick: l.tick}
+	l.nodes[key] = e
+	l.total += cost
+	heap.Push(&l.h, e)
+}
+
+func (l *lfu[K]) touch(key K) {
+	if e, ok := l.nodes[key]; ok {
+		l.tick++
+		e.freq++
+		e.tick = l.tick
+		heap.Fix(&l.h, e.index)
+	}
+}
+
+func (l *lfu[K]) remove(key K) {
+	if e, ok := l.nodes[key]; ok {
+		l.total -= e.cost
+		heap.Remove(&l.h, e.index)
+		delete(l.nodes, key)
+	}
+}
+
+// victim 新插入的key访问次数最少，如果总是淘汰它，新的key就永远进不来，
+// 所以跳过keep，取堆顶的两个子节点中较小的那个
+func (l *lfu[K]) victim(keep K) K {
+	h := l.h
+	if h[0].key != keep || len(h) == 1 {
+		return h[0].key
+	}
+	if len(h) > 2 && h.Less(2, 1) {
+		return h[2].key
+	}
+	return h[1].key
+}
+
+// walk 从最先被淘汰的开始
+func (l *lfu[K]) walk(fn func(key K, freq uint64)) {
+	entries := append([]*lfuEntry[K](nil), l.h...)
+	sort.Slice(entries, func(i, j int) bool {
+		return lfuHeap[K](entries).Less(i, j)
+	})
+	for _, e := range entries {
+		fn(e.key, e.freq)
+	}
+}
+
+func (l *lfu[K]) len() int    { return len(l.nodes) }
+func (l *lfu[K]) cost() int64 { return l.total }
+
+type lfuHeap[K comparable] []*lfuEntry[K]
+
+func (h lfuHeap[K]) Len() int { return len(h) }
+
+func (h lfuHeap[K]) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[K]) Push(x interface{}) {
+	e := x.(*lfuEntry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap[K]) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,212 @@
+package cmap
+
+import (
+	"sync"
+	"testing"
+)
+
+// 记录淘汰回调的参数
+type evictLog struct {
+	mu   sync.Mutex
+	keys []int
+	why  map[EvictReason]int
+}
+
+func (l *evictLog) cb(key, v int, reason EvictReason) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.why == nil {
+		l.why = make(map[EvictReason]int)
+	}
+	l.keys = append(l.keys, key)
+	l.why[reason]++
+}
+
+func TestLRU(t *testing.T) {
+	var log evictLog
+	m := New[int, int](IntegerHasher[int](), WithShardCount(1), WithMaxEntries(3),
+		WithEvictCallback(log.cb))
+	m.Set(1, 1)
+	m.Set(2, 2)
+	m.Set(3, 3)
+	m.Get(1) // 1变成最近访问的
+	m.Set(4, 4)
+	if m.Has(2) || !m.Has(1) || m.Count() != 3 {
+		t.Fatalf("expect 2 to be evicted, got %v", m.Items())
+	}
+	m.Set(3, 30) // 更新也算访问
+	m.Set(5, 5)
+	if m.Has(1) || !m.Has(3) {
+		t.Fatalf("expect 1 to be evicted, got %v", m.Items())
+	}
+	if len(log.keys) != 2 || log.keys[0] != 2 || log.keys[1] != 1 || log.why[EvictCapacity] != 2 {
+		t.Fatalf("unexpected evictions %v %v", log.keys, log.why)
+	}
+}
+
+func TestLFU(t *testing.T) {
+	m := New[int, int](IntegerHasher[int](), WithShardCount(1), WithMaxEntries(3),
+		WithEvictionPolicy(LFU))
+	m.Set(1, 1)
+	m.Set(2, 2)
+	m.Set(3, 3)
+	for i := 0; i < 3; i++ {
+		m.Get(1)
+		m.Get(3)
+	}
+	m.Get(2)
+	m.Set(4, 4) // 2访问的次数最少
+	if m.Has(2) || m.Count() != 3 {
+		t.Fatalf("expect 2 to be evicted, got %v", m.Items())
+	}
+	m.Set(5, 5) // 新插入的key不会被立即淘汰，淘汰访问次数同样是1的4
+	if m.Has(4) || !m.Has(5) || !m.Has(1) || !m.Has(3) {
+		t.Fatalf("expect 4 to be evicted, got %v", m.Items())
+	}
+}
+
+func TestMaxCost(t *testing.T) {
+	var log evictLog
+	m := New[int, int](IntegerHasher[int](), WithShardCount(1), WithMaxCost(10),
+		WithCost(func(key, v int) int64 { return int64(v) }),
+		WithEvictCallback(log.cb))
+	m.Set(1, 4)
+	m.Set(2, 4)
+	m.Set(3, 4) // 超过10，淘汰1
+	if m.Has(1) || m.Stats().Cost != 8 {
+		t.Fatalf("expect 1 to be evicted, got %v cost %d", m.Items(), m.Stats().Cost)
+	}
+	m.Upsert(2, 3, func(exist bool, v, n int) int { return v + n }) // 2的代价变成7
+	if m.Has(3) || m.Stats().Cost != 7 {
+		t.Fatalf("expect 3 to be evicted, got %v cost %d", m.Items(), m.Stats().Cost)
+	}
+	m.Set(4, 11) // 一个元素就超过上限，立即淘汰
+	if m.Has(4) || !m.Has(2) {
+		t.Fatalf("expect oversized 4 to be evicted, got %v", m.Items())
+	}
+	m.Remove(2)
+	if c := m.Stats().Cost; c != 0 {
+		t.Fatalf("expect cost 0 after remove but got %d", c)
+	}
+	if log.why[EvictCost] != 3 {
+		t.Fatalf("unexpected evictions %v", log.why)
+	}
+}
+
+func TestStats(t *testing.T) {
+	m := New[int, int](IntegerHasher[int](), WithShardCount(4), WithMaxEntries(8))
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	hits := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := m.Get(i); ok {
+			hits++
+		}
+	}
+	m.Resize(2) // 迁移之后计数不会丢
+	st := m.Stats()
+	if st.Hits != uint64(hits) || st.Misses != uint64(100-hits) {
+		t.Fatalf("expect %d hits but got %+v", hits, st)
+	}
+	if st.Evictions[EvictCapacity] != uint64(100-hits) {
+		t.Fatalf("expect %d evictions but got %+v", 100-hits, st)
+	}
+	if n := m.Count(); n > 8 {
+		t.Fatalf("expect at most 8 elements but got %d", n)
+	}
+}
+
+// 没有容量限制时Get不写计数
+func TestUnboundedNoCounters(t *testing.T) {
+	m := New[int, int](IntegerHasher[int]())
+	m.Set(1, 1)
+	m.Get(1)
+	m.Get(2)
+	if st := m.Stats(); st.Hits != 0 || st.Misses != 0 {
+		t.Fatalf("expect no hit counters for an unbounded map but got %+v", st)
+	}
+}
+
+// 迁移时保留访问的先后顺序。每个分片只知道自己的元素的先后，
+// 所以这里从一个分片拆成两个。
+func TestBoundedResize(t *testing.T) {
+	m := New[int, int](IntegerHasher[int](), WithShardCount(1), WithMaxEntries(400))
+	for i := 0; i < 400; i++ {
+		m.Set(i, i)
+	}
+	for i := 0; i < 400; i += 2 {
+		m.Get(i)
+	}
+	m.Resize(2) // 每个分片最多200个
+	for i := 400; i < 500; i++ {
+		m.Set(i, i)
+	}
+	for i := 0; i < 400; i += 2 {
+		if !m.Has(i) {
+			t.Fatalf("recently used key %d evicted", i)
+		}
+	}
+	if n := m.Count(); n > 400 {
+		t.Fatalf("expect at most 400 elements but got %d", n)
+	}
+}
+
+func TestBoundedConcurrent(t *testing.T) {
+	for _, p := range []EvictionPolicy{LRU, LFU} {
+		m := New[int, int](IntegerHasher[int](), WithShardCount(8), WithMaxEntries(64),
+			WithEvictionPolicy(p))
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 2000; i++ {
+					key := (i*7 + g) % 500
+					m.Set(key, i)
+					m.Get((key + 1) % 500)
+					if i%100 == 0 {
+						m.Remove(key)
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		if n := m.Count(); n > 64 {
+			t.Fatalf("%v: expect at most 64 elements but got %d", p, n)
+		}
+		m.resizeMu.RLock()
+		for _, s := range m.shards() {
+			if s.policy.len() != len(s.items) {
+				t.Fatalf("%v: tracker out of sync, %d vs %d", p, s.policy.len(), len(s.items))
+			}
+		}
+		m.resizeMu.RUnlock()
+	}
+}
+
+// Resize的过程中Stats不会少算已经迁移的分片
+func TestStatsDuringResize(t *testing.T) {
+	m := New[int, int](IntegerHasher[int](), WithShardCount(2), WithMaxEntries(1<<20))
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+		m.Get(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, n := range []int{8, 64, 5, 256, 16} {
+			m.Resize(n)
+		}
+	}()
+	for {
+		if st := m.Stats(); st.Hits != 1000 {
+			t.Fatalf("expect 1000 hits during resize but got %d", st.Hits)
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,576 @@
+// Package cmap 提供泛型的分片并发map。
+//
+// Map/cmap_test.go中的ConcurrentMap只支持string类型的key，值是interface{}，
+// 每次取出来都要类型断言。这里的ConcurrentMap[K, V]保留了它的全部API，
+// key可以是任意comparable的类型，通过Hasher选择分片。
+//
+// 原来的分片数量SHARD_COUNT是包级别的变量，所有map共用，创建map之后再修改它
+// GetShard就错了。这里分片数量是每个map自己的，可以在创建时通过WithShardCount
+// 指定，也可以在使用过程中调用Resize在线调整。
+//
+// 作为缓存使用时，可以用SetWithTTL设置元素的存活时间，过期的元素立即不可见，
+// 每个map有一个后台goroutine定期删除它们，删除时可以通过WithEvictCallback得到通知。
+// 使用了SetWithTTL的map不再使用时必须调用Close停止这个goroutine。
+//
+// 通过WithMaxEntries或者WithMaxCost可以限制map的大小，每个分片按LRU或者LFU
+// 淘汰自己的元素，淘汰的记录和分片的锁一样是分开的，没有全局的锁。
+//
+// 原来的版本值是interface{}，没法实现UnmarshalJSON。这里V是具体的类型，
+// 可以用EncodeJSON/DecodeJSON或者WriteTo/ReadFrom（gob）一个分片一个分片地序列化到流中。
+package cmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShardCount 默认的分片数量
+const DefaultShardCount = 32
+
+// ConcurrentMap 分片的并发map，每个分片有自己的读写锁。必须使用New创建。
+type ConcurrentMap[K comparable, V any] struct {
+	retired counters // 已经迁移走的分片的计数
+
+	hash  Hasher[K]
+	table atomic.Value // *table[K, V]
+
+	// Resize每迁移一个分片持有写锁，Count这些读所有分片的操作持有读锁，
+	// 这样不会看到迁移了一半的分片。只访问一个key时不需要它。
+	resizeMu sync.RWMutex
+	resizing sync.Mutex // Resize之间的互斥，会调用回调的遍历也持有它，见pinShards
+
+	onEvict       EvictCb[K, V]
+	sweepInterval time.Duration
+	janitor       janitor
+
+	maxEntries int
+	maxCost    int64
+	cost       CostFunc[K, V]
+	policy     EvictionPolicy
+}
+
+// Shard 一个分片
+type Shard[K comparable, V any] struct {
+	counters
+
+	sync.RWMutex // 保护items
+	items        map[K]V
+	expires      map[K]int64      // 设置了存活时间的key的过期时间，纳秒
+	migrated     bool             // 元素已经迁移到新表，需要到新表中访问
+	pending      []eviction[K, V] // 持有写锁时淘汰的元素
+
+	// 有容量限制时的淘汰记录。Get只持有读锁也要更新它，所以有单独的锁。
+	policyMu   sync.Mutex
+	policy     tracker[K]
+	maxEntries int64
+	maxCost    int64
+}
+
+// table 一组分片
+type table[K comparable, V any] struct {
+	shards []*Shard[K, V]
+	next   *table[K, V] // Resize时正在迁移到的新表
+}
+
+func (m *ConcurrentMap[K, V]) newTable(n int) *table[K, V] {
+	t := &table[K, V]{shards: make([]*Shard[K, V], n)}
+	for i := range t.shards {
+		s := &Shard[K, V]{items: make(map[K]V)}
+		if m.bounded() {
+			s.policy = newTracker[K](m.policy)
+			s.maxEntries = limit(int64(m.maxEntries), n)
+			s.maxCost = limit(m.maxCost, n)
+		}
+		t.shards[i] = s
+	}
+	return t
+}
+
+func (t *table[K, V]) shard(hash uint32) *Shard[K, V] {
+	return t.shards[uint(hash)%uint(len(t.shards))]
+}
+
+type options struct {
+	shardCount    int
+	sweepInterval time.Duration
+	onEvict       interface{} // EvictCb[K, V]
+	maxEntries    int
+	maxCost       int64
+	cost          interface{} // CostFunc[K, V]
+	policy        EvictionPolicy
+}
+
+// Option ConcurrentMap的配置项
+type Option func(*options)
+
+// WithShardCount 设置分片的数量，默认是DefaultShardCount
+func WithShardCount(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.shardCount = n
+		}
+	}
+}
+
+// New 创建一个ConcurrentMap，hasher计算key的哈希值
+func New[K comparable, V any](hasher Hasher[K], opts ...Option) *ConcurrentMap[K, V] {
+	o := options{shardCount: DefaultShardCount, sweepInterval: DefaultSweepInterval}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := &ConcurrentMap[K, V]{
+		hash:          hasher,
+		sweepInterval: o.sweepInterval,
+		maxEntries:    o.maxEntries,
+		maxCost:       o.maxCost,
+		policy:        o.policy,
+	}
+	if o.onEvict != nil {
+		cb, ok := o.onEvict.(EvictCb[K, V])
+		if !ok {
+			panic("cmap: eviction callback does not match the map's key and value types")
+		}
+		m.onEvict = cb
+	}
+	if o.cost != nil {
+		fn, ok := o.cost.(CostFunc[K, V])
+		if !ok {
+			panic("cmap: cost function does not match the map's key and value types")
+		}
+		m.cost = fn
+	}
+	m.table.Store(m.newTable(o.shardCount))
+	return m
+}
+
+// NewString 创建一个key是字符串的ConcurrentMap，和原来的ConcurrentMap一样使用fnv32
+func NewString[V any](opts ...Option) *ConcurrentMap[string, V] {
+	return New[string, V](StringHasher[string](), opts...)
+}
+
+func (m *ConcurrentMap[K, V]) load() *table[K, V] {
+	return m.table.Load().(*table[K, V])
+}
+
+// GetShard 返回key当前所在的分片。Resize时key可能会被迁移到别的分片，
+// 所以锁住返回的分片之后不能假定key还在这个分片中。
+func (m *ConcurrentMap[K, V]) GetShard(key K) *Shard[K, V] {
+	m.resizeMu.RLock()
+	defer m.resizeMu.RUnlock()
+	t := m.load()
+	if t.next != nil {
+		t = t.next
+	}
+	return t.shard(m.hash(key))
+}
+
+// ShardCount 分片的数量，Resize的过程中返回新的分片数量
+func (m *ConcurrentMap[K, V]) ShardCount() int {
+	m.resizeMu.RLock()
+	defer m.resizeMu.RUnlock()
+	t := m.load()
+	if t.next != nil {
+		t = t.next
+	}
+	return len(t.shards)
+}
+
+// lock 锁住key所在的分片。分片已经迁移的话，到新表中找。
+func (m *ConcurrentMap[K, V]) lock(key K) *Shard[K, V] {
+	h := m.hash(key)
+	for t := m.load(); ; t = t.next {
+		s := t.shard(h)
+		s.Lock()
+		if !s.migrated {
+			return s
+		}
+		s.Unlock()
+	}
+}
+
+// rlock 以读锁锁住key所在的分片
+func (m *ConcurrentMap[K, V]) rlock(key K) *Shard[K, V] {
+	h := m.hash(key)
+	for t := m.load(); ; t = t.next {
+		s := t.shard(h)
+		s.RLock()
+		if !s.migrated {
+			return s
+		}
+		s.RUnlock()
+	}
+}
+
+// shards 当前保存着元素的所有分片，需要持有resizeMu的读锁
+func (m *ConcurrentMap[K, V]) shards() []*Shard[K, V] {
+	t := m.load()
+	if t.next == nil {
+		return t.shards
+	}
+	shards := make([]*Shard[K, V], 0, len(t.shards)+len(t.next.shards))
+	for _, s := range t.shards {
+		if !s.migrated {
+			shards = append(shards, s)
+		}
+	}
+	return append(shards, t.next.shards...)
+}
+
+// pinShards 返回当前保存着元素的所有分片，在调用返回的函数之前Resize会等待，
+// 这些分片不会被迁移。只在复制分片列表时持有resizeMu的读锁，之后调用Count、GetShard
+// 这些需要resizeMu读锁的方法不会和等待写锁的Resize死锁；但是期间不能调用Resize。
+func (m *ConcurrentMap[K, V]) pinShards() ([]*Shard[K, V], func()) {
+	m.resizing.Lock()
+	m.resizeMu.RLock()
+	shards := m.shards()
+	m.resizeMu.RUnlock()
+	return shards, m.resizing.Unlock
+}
+
+// Resize 把分片数量调整为n，一个分片一个分片地迁移元素。
+// 迁移期间只有正在迁移的分片会被锁住，其它key的读写不受影响。
+func (m *ConcurrentMap[K, V]) Resize(n int) {
+	if n <= 0 {
+		panic("cmap: shard count must be positive")
+	}
+	m.resizing.Lock()
+	defer m.resizing.Unlock()
+	old := m.load()
+	if len(old.shards) == n {
+		return
+	}
+	next := m.newTable(n)
+	m.resizeMu.Lock()
+	old.next = next
+	m.resizeMu.Unlock()
+
+	for _, s := range old.shards {
+		m.resizeMu.Lock()
+		s.Lock()
+		evicted := m.migrate(s, next)
+		s.Unlock()
+		m.resizeMu.Unlock()
+		for _, e := range evicted {
+			m.evict(e.key, e.val, e.reason)
+		}
+	}
+
+	m.resizeMu.Lock()
+	m.table.Store(next)
+	m.resizeMu.Unlock()
+}
+
+// migrate 把分片s的元素移到新表，需要持有s的锁。有容量限制时按淘汰的顺序插入，
+// 保留访问的先后和次数；新的分片放不下时淘汰的元素返回给调用者，释放锁之后再调用回调。
+func (m *ConcurrentMap[K, V]) migrate(s *Shard[K, V], next *table[K, V]) (evicted []eviction[K, V]) {
+	type migrant struct {
+		key  K
+		freq uint64
+	}
+	// 先按目标分片分组，每个目标分片只锁一次
+	groups := make(map[*Shard[K, V]][]migrant)
+	add := func(key K, freq uint64) {
+		ns := next.shard(m.hash(key))
+		groups[ns] = append(groups[ns], migrant{key, freq})
+	}
+	if s.policy != nil {
+		s.policy.walk(add)
+	} else {
+		for key := range s.items {
+			add(key, 0)
+		}
+	}
+	for ns, keys := range groups {
+		ns.Lock()
+		for _, k := range keys {
+			val := s.items[k.key]
+			ns.items[k.key] = val
+			if e, ok := s.expires[k.key]; ok {
+				if ns.expires == nil {
+					ns.expires = make(map[K]int64)
+				}
+				ns.expires[k.key] = e
+			}
+			if ns.policy != nil {
+				ns.policyMu.Lock()
+				ns.policy.restore(k.key, m.costOf(k.key, val), k.freq)
+				ns.shrink(k.key)
+				ns.policyMu.Unlock()
+			}
+		}
+		evicted = append(evicted, ns.pending...)
+		ns.pending = nil
+		ns.Unlock()
+	}
+	m.retired.add(&s.counters)
+	s.items = nil
+	s.expires = nil
+	s.policy = nil
+	s.migrated = true
+	return evicted
+}
+
+// MSet 设置多个键值对
+func (m *ConcurrentMap[K, V]) MSet(data map[K]V) {
+	for key, value := range data {
+		shard := m.lock(key)
+		m.put(shard, key, value)
+		delete(shard.expires, key)
+		m.unlock(shard)
+	}
+}
+
+// Set 设置key的值，不会过期
+func (m *ConcurrentMap[K, V]) Set(key K, value V) {
+	shard := m.lock(key)
+	m.put(shard, key, value)
+	delete(shard.expires, key)
+	m.unlock(shard)
+}
+
+// UpsertCb 返回要插入的新值。调用时持有分片的锁，所以不能访问同一个map的其它key，
+// 否则可能死锁，sync.RWMutex不是可重入的。
+type UpsertCb[V any] func(exist bool, valueInMap V, newValue V) V
+
+// Upsert 插入或者更新：用cb的返回值更新已有的值，或者插入新值。
+// 更新时保留原来的过期时间，已经过期的元素当作不存在。
+func (m *ConcurrentMap[K, V]) Upsert(key K, value V, cb UpsertCb[V]) (res V) {
+	shard := m.lock(key)
+	shard.removeExpired(key)
+	v, ok := shard.items[key]
+	res = cb(ok, v, value)
+	m.put(shard, key, res)
+	m.unlock(shard)
+	return res
+}
+
+// SetIfAbsent key不存在时设置它的值，返回是否设置了
+func (m *ConcurrentMap[K, V]) SetIfAbsent(key K, value V) bool {
+	shard := m.lock(key)
+	shard.removeExpired(key)
+	_, ok := shard.items[key]
+	if !ok {
+		m.put(shard, key, value)
+	}
+	m.unlock(shard)
+	return !ok
+}
+
+// Get 返回key的值，有容量限制时算作一次访问，并且计入命中或者未命中
+func (m *ConcurrentMap[K, V]) Get(key K) (V, bool) {
+	shard := m.rlock(key)
+	val, ok := shard.items[key]
+	expired := ok && shard.expired(key)
+	if expired {
+		var zero V
+		val, ok = zero, false
+	}
+	if shard.policy != nil { // 没有容量限制时不计数，reader不写共享的缓存行
+		if ok {
+			shard.touch(key)
+			atomic.AddUint64(&shard.hits, 1)
+		} else {
+			atomic.AddUint64(&shard.misses, 1)
+		}
+	}
+	shard.RUnlock()
+	if expired {
+		m.expire(key)
+	}
+	return val, ok
+}
+
+// Count 元素的数量。和原来的版本一样只累加每个分片的大小，
+// 包括已经过期、还没有被访问或者后台清理删除的元素。
+func (m *ConcurrentMap[K, V]) Count() int {
+	m.resizeMu.RLock()
+	defer m.resizeMu.RUnlock()
+	count := 0
+	for _, shard := range m.shards() {
+		shard.RLock()
+		count += len(shard.items)
+		shard.RUnlock()
+	}
+	return count
+}
+
+// Has key是否存在，不算作访问
+func (m *ConcurrentMap[K, V]) Has(key K) bool {
+	shard := m.rlock(key)
+	_, ok := shard.items[key]
+	expired := ok && shard.expired(key)
+	shard.RUnlock()
+	if expired {
+		m.expire(key)
+	}
+	return ok && !expired
+}
+
+// Remove 删除key
+func (m *ConcurrentMap[K, V]) Remove(key K) {
+	shard := m.lock(key)
+	shard.del(key)
+	shard.Unlock()
+}
+
+// RemoveCb 在持有分片锁的时候调用，返回true并且元素存在时删除它
+type RemoveCb[K comparable, V any] func(key K, v V, exists bool) bool
+
+// RemoveCb 锁住key所在的分片，用key当前的值调用cb，cb返回true并且元素存在时删除它。
+// 返回cb的返回值，即使元素不存在。
+func (m *ConcurrentMap[K, V]) RemoveCb(key K, cb RemoveCb[K, V]) bool {
+	shard := m.lock(key)
+	shard.removeExpired(key)
+	v, ok := shard.items[key]
+	remove := cb(key, v, ok)
+	if remove && ok {
+		shard.del(key)
+	}
+	m.unlock(shard)
+	return remove
+}
+
+// Pop 删除key并返回它的值
+func (m *ConcurrentMap[K, V]) Pop(key K) (v V, exists bool) {
+	shard := m.lock(key)
+	shard.removeExpired(key)
+	v, exists = shard.items[key]
+	if exists {
+		shard.del(key)
+	}
+	m.unlock(shard)
+	return v, exists
+}
+
+// IsEmpty map是否为空，和Count一样包括过期的元素
+func (m *ConcurrentMap[K, V]) IsEmpty() bool {
+	return m.Count() == 0
+}
+
+// Tuple Iter和IterBuffered通过channel返回的键值对
+type Tuple[K comparable, V any] struct {
+	Key K
+	Val V
+}
+
+// Iter 返回一个可以for range的迭代器。
+//
+// Deprecated: IterBuffered的性能更好
+func (m *ConcurrentMap[K, V]) Iter() <-chan Tuple[K, V] {
+	chans := snapshot(m)
+	ch := make(chan Tuple[K, V])
+	go fanIn(chans, ch)
+	return ch
+}
+
+// IterBuffered 返回一个带缓冲的迭代器
+func (m *ConcurrentMap[K, V]) IterBuffered() <-chan Tuple[K, V] {
+	chans := snapshot(m)
+	total := 0
+	for _, c := range chans {
+		total += cap(c)
+	}
+	ch := make(chan Tuple[K, V], total)
+	go fanIn(chans, ch)
+	return ch
+}
+
+// snapshot 为每个分片返回一个包含它的元素的channel，
+// channel的大小确定之后就返回，元素由各自的goroutine填充
+func snapshot[K comparable, V any](m *ConcurrentMap[K, V]) []chan Tuple[K, V] {
+	m.resizeMu.RLock()
+	defer m.resizeMu.RUnlock()
+	shards := m.shards()
+	chans := make([]chan Tuple[K, V], len(shards))
+	var wg sync.WaitGroup
+	wg.Add(len(shards))
+	for index, shard := range shards {
+		go func(index int, shard *Shard[K, V]) {
+			shard.RLock()
+			now := nanotime()
+			chans[index] = make(chan Tuple[K, V], len(shard.items))
+			wg.Done()
+			for key, val := range shard.items {
+				if !shard.expiredAt(key, now) {
+					chans[index] <- Tuple[K, V]{key, val}
+				}
+			}
+			shard.RUnlock()
+			close(chans[index])
+		}(index, shard)
+	}
+	wg.Wait()
+	return chans
+}
+
+// fanIn 把chans中的元素都读到out中
+func fanIn[K comparable, V any](chans []chan Tuple[K, V], out chan Tuple[K, V]) {
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, ch := range chans {
+		go func(ch chan Tuple[K, V]) {
+			for t := range ch {
+				out <- t
+			}
+			wg.Done()
+		}(ch)
+	}
+	wg.Wait()
+	close(out)
+}
+
+// Items 以map[K]V返回所有元素
+func (m *ConcurrentMap[K, V]) Items() map[K]V {
+	tmp := make(map[K]V)
+	for item := range m.IterBuffered() {
+		tmp[item.Key] = item.Val
+	}
+	return tmp
+}
+
+// IterCb 迭代的回调函数。调用时持有这个分片的读锁，所以在一个分片内看到的是一致的，
+// 但是不同分片之间不是，需要整个map一致的视图时用Snapshot
+type IterCb[K comparable, V any] func(key K, v V)
+
+// IterCb 用回调函数遍历所有元素，开销最小。
+// 遍历期间Resize会等待；fn可以调用Count、GetShard这些方法，
+// 但是不能调用Resize，也不能写这个分片中的key。
+func (m *ConcurrentMap[K, V]) IterCb(fn IterCb[K, V]) {
+	shards, release := m.pinShards()
+	defer release()
+	for _, shard := range shards {
+		shard.RLock()
+		now := nanotime()
+		for key, value := range shard.items {
+			if !shard.expiredAt(key, now) {
+				fn(key, value)
+			}
+		}
+		shard.RUnlock()
+	}
+}
+
+// Keys 返回所有的key
+func (m *ConcurrentMap[K, V]) Keys() []K {
+	keys := make([]K, 0, m.Count())
+	shards, release := m.pinShards()
+	defer release()
+	for _, shard := range shards {
+		shard.RLock()
+		now := nanotime()
+		for key := range shard.items {
+			if !shard.expiredAt(key, now) {
+				keys = append(keys, key)
+			}
+		}
+		shard.RUnlock()
+	}
+	return keys
+}
+
+// MarshalJSON 把所有元素编码为一个JSON对象，K需要是字符串、整数或者实现了encoding.TextMarshaler。
+// 编码的是Snapshot，写操作不会被阻塞到编码结束。
+func (m *ConcurrentMap[K, V]) MarshalJSON() ([]byte, error) {
+	return m.Snapshot().MarshalJSON()
+}
@@ -0,0 +1,267 @@
+package cmap
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type Animal struct {
+	name string
+}
+
+func TestMapCreation(t *testing.T) {
+	m := NewString[Animal]()
+	if m == nil {
+		t.Fatal("map is null.")
+	}
+	if m.Count() != 0 || !m.IsEmpty() {
+		t.Fatal("new map should be empty.")
+	}
+}
+
+func TestSetGetRemove(t *testing.T) {
+	m := NewString[Animal]()
+	m.Set("elephant", Animal{"elephant"})
+	m.Set("monkey", Animal{"monkey"})
+	if m.Count() != 2 {
+		t.Fatalf("expect 2 elements but got %d", m.Count())
+	}
+	if v, ok := m.Get("monkey"); !ok || v.name != "monkey" {
+		t.Fatalf("unexpected value %v, %v", v, ok)
+	}
+	if _, ok := m.Get("lion"); ok {
+		t.Fatal("missing values should not be found")
+	}
+	m.Remove("monkey")
+	if m.Has("monkey") {
+		t.Fatal("expecting element to be removed")
+	}
+	if v, ok := m.Pop("elephant"); !ok || v.name != "elephant" || !m.IsEmpty() {
+		t.Fatalf("unexpected pop %v, %v", v, ok)
+	}
+	if _, ok := m.Pop("elephant"); ok {
+		t.Fatal("pop of missing key should fail")
+	}
+}
+
+func TestUpsertAndSetIfAbsent(t *testing.T) {
+	m := NewString[[]string]()
+	cb := func(exists bool, valueInMap []string, newValue []string) []string {
+		return append(valueInMap, newValue...)
+	}
+	m.Upsert("marine", []string{"whale"}, cb)
+	m.Upsert("marine", []string{"dolphin"}, cb)
+	if v, _ := m.Get("marine"); len(v) != 2 || v[1] != "dolphin" {
+		t.Fatalf("unexpected upsert result %v", v)
+	}
+	if m.SetIfAbsent("marine", nil) {
+		t.Fatal("SetIfAbsent on existing key should fail")
+	}
+	if !m.SetIfAbsent("predator", []string{"tiger"}) {
+		t.Fatal("SetIfAbsent on missing key should succeed")
+	}
+}
+
+func TestRemoveCb(t *testing.T) {
+	m := NewString[int]()
+	m.MSet(map[string]int{"a": 1, "b": 2})
+	var gotKey string
+	var gotExists bool
+	cb := func(key string, v int, exists bool) bool {
+		gotKey, gotExists = key, exists
+		return v == 1
+	}
+	if !m.RemoveCb("a", cb) || gotKey != "a" || !gotExists || m.Has("a") {
+		t.Fatal("expect a to be removed")
+	}
+	if m.RemoveCb("b", cb) || !m.Has("b") {
+		t.Fatal("expect b to be kept")
+	}
+	if m.RemoveCb("c", cb) || gotExists {
+		t.Fatal("missing key should be reported as not existing")
+	}
+}
+
+func TestIteration(t *testing.T) {
+	m := New[int, int](IntegerHasher[int]())
+	for i := 0; i < 100; i++ {
+		m.Set(i, i*i)
+	}
+	counter := 0
+	for item := range m.Iter() {
+		if item.Val != item.Key*item.Key {
+			t.Fatalf("unexpected item %v", item)
+		}
+		counter++
+	}
+	for range m.IterBuffered() {
+		counter++
+	}
+	m.IterCb(func(key, v int) {
+		counter++
+	})
+	if counter != 300 {
+		t.Fatalf("expect 300 iterations but got %d", counter)
+	}
+	if items := m.Items(); len(items) != 100 || items[9] != 81 {
+		t.Fatalf("unexpected items %v", items)
+	}
+	keys := m.Keys()
+	sort.Ints(keys)
+	if len(keys) != 100 || keys[99] != 99 {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	m := New[int, string](IntegerHasher[int]())
+	m.Set(1, "one")
+	m.Set(2, "two")
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"1":"one","2":"two"}` {
+		t.Fatalf("unexpected json %s", b)
+	}
+}
+
+type point struct {
+	X, Y int32
+}
+
+func pointHash(p point) uint32 {
+	return mix64(uint64(uint32(p.X))<<32 | uint64(uint32(p.Y)))
+}
+
+// 结构体和字节序列的key
+func TestHashers(t *testing.T) {
+	pm := New[point, string](pointHash)
+	pm.Set(point{1, 2}, "a")
+	if v, ok := pm.Get(point{1, 2}); !ok || v != "a" {
+		t.Fatalf("unexpected value %v, %v", v, ok)
+	}
+
+	type uuid [16]byte
+	um := New[uuid, int](BytesHasher(func(u uuid) []byte { return u[:] }))
+	var id uuid
+	binary.BigEndian.PutUint64(id[:], 42)
+	um.Set(id, 42)
+	if v, ok := um.Get(id); !ok || v != 42 {
+		t.Fatalf("unexpected value %v, %v", v, ok)
+	}
+
+	// 连续的整数应该分散在所有分片上
+	im := New[int, int](IntegerHasher[int]())
+	for i := 0; i < DefaultShardCount*16; i++ {
+		im.Set(i, i)
+	}
+	for i, shard := range im.load().shards {
+		if len(shard.items) == 0 {
+			t.Fatalf("shard %d is empty", i)
+		}
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	m := NewString[int]()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa(j)
+				m.Upsert(key, 1, func(exist bool, v, n int) int { return v + n })
+			}
+		}(i)
+	}
+	wg.Wait()
+	if m.Count() != 1000 {
+		t.Fatalf("expect 1000 keys but got %d", m.Count())
+	}
+	m.IterCb(func(key string, v int) {
+		if v != 10 {
+			t.Errorf("expect 10 for %s but got %d", key, v)
+		}
+	})
+}
+
+var benchKeys = func() []string {
+	keys := make([]string, 1<<12)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	return keys
+}()
+
+func BenchmarkSet(b *testing.B) {
+	b.Run("legacy", func(b *testing.B) {
+		m := newLegacy()
+		for i := 0; i < b.N; i++ {
+			m.Set(benchKeys[i&(len(benchKeys)-1)], i)
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		m := NewString[int]()
+		for i := 0; i < b.N; i++ {
+			m.Set(benchKeys[i&(len(benchKeys)-1)], i)
+		}
+	})
+}
+
+func BenchmarkGet(b *testing.B) {
+	b.Run("legacy", func(b *testing.B) {
+		m := newLegacy()
+		for i, key := range benchKeys {
+			m.Set(key, i)
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			sum, i := 0, 0
+			for pb.Next() {
+				v, _ := m.Get(benchKeys[i&(len(benchKeys)-1)])
+				sum += v.(int) // 原来的版本需要类型断言
+				i++
+			}
+		})
+	})
+	b.Run("generic", func(b *testing.B) {
+		m := NewString[int]()
+		for i, key := range benchKeys {
+			m.Set(key, i)
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			sum, i := 0, 0
+			for pb.Next() {
+				v, _ := m.Get(benchKeys[i&(len(benchKeys)-1)])
+				sum += v
+				i++
+			}
+		})
+	})
+}
+
+// 整数key：原来的版本要先转换成字符串
+func BenchmarkIntegerKeys(b *testing.B) {
+	b.Run("legacy", func(b *testing.B) {
+		m := newLegacy()
+		for i := 0; i < b.N; i++ {
+			key := strconv.Itoa(i & 4095)
+			m.Set(key, i)
+			m.Get(key)
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		m := New[int, int](IntegerHasher[int]())
+		for i := 0; i < b.N; i++ {
+			m.Set(i&4095, i)
+			m.Get(i & 4095)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package cmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 解码JSON时每次MSet的元素数量
+const decodeBatch = 64
+
+var errNotInitialized = errors.New("cmap: map must be created with New before decoding")
+
+// liveItems 分片中没有过期的元素，持有读锁复制出来，编码时不持有锁
+func (s *Shard[K, V]) liveItems() []Tuple[K, V] {
+	s.RLock()
+	defer s.RUnlock()
+	items := make([]Tuple[K, V], 0, len(s.items))
+	now := nanotime()
+	for key, val := range s.items {
+		if !s.expiredAt(key, now) {
+			items = append(items, Tuple[K, V]{key, val})
+		}
+	}
+	return items
+}
+
+// eachShard 依次用每个分片的元素调用fn，fn返回错误时停止。
+// 期间Resize会等待，否则还没有读到的分片可能已经迁移走了。
+func (m *ConcurrentMap[K, V]) eachShard(fn func(items []Tuple[K, V]) error) error {
+	shards, release := m.pinShards()
+	defer release()
+	for _, s := range shards {
+		items := s.liveItems()
+		if len(items) == 0 {
+			continue
+		}
+		if err := fn(items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeJSON 把所有元素编码为一个JSON对象写到w，格式和MarshalJSON一样。
+// 一个分片一个分片地编码，不会构造包含所有元素的map，所以和IterCb一样只在一个分片内是一致的。
+// 编码期间调用Resize会等到编码结束。
+func (m *ConcurrentMap[K, V]) EncodeJSON(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte('{')
+	first := true
+	err := m.eachShard(func(items []Tuple[K, V]) error {
+		// 借用encoding/json对map的key的编码规则，每次只编码一个分片
+		tmp := make(map[K]V, len(items))
+		for _, t := range items {
+			tmp[t.Key] = t.Val
+		}
+		b, err := json.Marshal(tmp)
+		if err != nil {
+			return err
+		}
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		_, err = bw.Write(b[1 : len(b)-1]) // 去掉两边的大括号
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	bw.WriteByte('}')
+	return bw.Flush()
+}
+
+// DecodeJSON 从r读取一个JSON对象，把其中的键值对设置到map中，已有的元素会保留。
+// 一边读一边设置，每次只解码一小批元素，不会构造包含所有元素的map。
+// map必须是New创建的，解码出错时已经设置的元素不会撤销。
+func (m *ConcurrentMap[K, V]) DecodeJSON(r io.Reader) error {
+	if m.hash == nil {
+		return errNotInitialized
+	}
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil { // null
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("cmap: expect a JSON object but got %v", tok)
+	}
+
+	// 把一批键值对重新拼成一个小的JSON对象，由encoding/json转换成K和V
+	var buf bytes.Buffer
+	n := 0
+	flush := func() error {
+		if n == 0 {
+			return nil
+		}
+		buf.WriteByte('}')
+		batch := make(map[K]V, n)
+		if err := json.Unmarshal(buf.Bytes(), &batch); err != nil {
+			return err
+		}
+		m.MSet(batch)
+		buf.Reset()
+		n = 0
+		return nil
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			return err
+		}
+		kb, _ := json.Marshal(key)
+		if n == 0 {
+			buf.WriteByte('{')
+		} else {
+			buf.WriteByte(',')
+		}
+		buf.Write(kb)
+		buf.WriteByte(':')
+		buf.Write(val)
+		if n++; n == decodeBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := dec.Token(); err != nil { // }
+		return err
+	}
+	return flush()
+}
+
+// UnmarshalJSON 和DecodeJSON一样，已有的元素会保留。
+// 因为需要Hasher，不能解码到零值的ConcurrentMap中，要先用New创建。
+func (m *ConcurrentMap[K, V]) UnmarshalJSON(b []byte) error {
+	return m.DecodeJSON(bytes.NewReader(b))
+}
+
+// binaryChunk 二进制格式中的一段，对应一个分片，End表示结束。
+// 整个格式是连续的gob消息，K和V需要能被gob编码。
+type binaryChunk[K comparable, V any] struct {
+	Items []Tuple[K, V]
+	End   bool
+}
+
+// countingWriter 记录写了多少字节，用于WriteTo的返回值
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteTo 把所有元素以二进制格式写到w，一个分片编码为一段，实现io.WriterTo。
+// 和EncodeJSON一样只在一个分片内是一致的。
+func (m *ConcurrentMap[K, V]) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	enc := gob.NewEncoder(cw)
+	err := m.eachShard(func(items []Tuple[K, V]) error {
+		return enc.Encode(binaryChunk[K, V]{Items: items})
+	})
+	if err == nil {
+		err = enc.Encode(binaryChunk[K, V]{End: true})
+	}
+	return cw.n, err
+}
+
+// countingReader 记录读了多少字节，用于ReadFrom的返回值
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadFrom 读取WriteTo写的二进制格式，一段一段地设置到map中，已有的元素会保留，实现io.ReaderFrom。
+// gob会预读，r中紧跟在后面的数据可能被读掉。
+func (m *ConcurrentMap[K, V]) ReadFrom(r io.Reader) (int64, error) {
+	if m.hash == nil {
+		return 0, errNotInitialized
+	}
+	cr := &countingReader{r: r}
+	dec := gob.NewDecoder(cr)
+	for {
+		var chunk binaryChunk[K, V]
+		if err := dec.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return cr.n, err
+		}
+		for _, t := range chunk.Items {
+			m.Set(t.Key, t.Val)
+		}
+		if chunk.End {
+			return cr.n, nil
+		}
+	}
+}
+
+// MarshalBinary 以WriteTo的格式编码，实现encoding.BinaryMarshaler
+func (m *ConcurrentMap[K, V]) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary 和ReadFrom一样，实现encoding.BinaryUnmarshaler
+func (m *ConcurrentMap[K, V]) UnmarshalBinary(data []byte) error {
+	_, err := m.ReadFrom(bytes.NewReader(data))
+	return err
+}
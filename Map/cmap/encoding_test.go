@@ -0,0 +1,171 @@
+package cmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type account struct {
+	Name    string
+	Balance int64
+	Tags    []string
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	m := New[int, account](IntegerHasher[int](), WithShardCount(8))
+	for i := 0; i < 300; i++ {
+		m.Set(i, account{Name: "user" + strconv.Itoa(i), Balance: int64(i), Tags: []string{"a"}})
+	}
+	var buf bytes.Buffer
+	if err := m.EncodeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// 和MarshalJSON的结果是同一个JSON对象
+	var a, b map[string]account
+	if err := json.Unmarshal(buf.Bytes(), &a); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	mb, _ := json.Marshal(m)
+	json.Unmarshal(mb, &b)
+	if len(a) != 300 || len(b) != 300 {
+		t.Fatalf("expect 300 elements but got %d and %d", len(a), len(b))
+	}
+
+	restored := New[int, account](IntegerHasher[int]())
+	restored.Set(1000, account{Name: "kept"})
+	if err := restored.DecodeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Count() != 301 {
+		t.Fatalf("expect 301 elements but got %d", restored.Count())
+	}
+	for i := 0; i < 300; i++ {
+		v, ok := restored.Get(i)
+		if !ok || v.Balance != int64(i) || v.Name != "user"+strconv.Itoa(i) || len(v.Tags) != 1 {
+			t.Fatalf("key %d: unexpected value %+v, %v", i, v, ok)
+		}
+	}
+
+	// json.Unmarshal调用UnmarshalJSON
+	sm := NewString[int]()
+	if err := json.Unmarshal([]byte(`{"a":1,"b\"c":2}`), sm); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := sm.Get(`b"c`); v != 2 || sm.Count() != 2 {
+		t.Fatalf("unexpected items %v", sm.Items())
+	}
+	if err := json.Unmarshal([]byte(`null`), sm); err != nil || sm.Count() != 2 {
+		t.Fatalf("null should be a no-op, got %v", err)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	m := New[int, int](IntegerHasher[int]())
+	for _, s := range []string{`[1]`, `{"a":1}`, `{"1":"x"}`, `{"1":1`} {
+		if err := m.DecodeJSON(strings.NewReader(s)); err == nil {
+			t.Fatalf("expect error for %s", s)
+		}
+	}
+	var zero ConcurrentMap[int, int]
+	if err := zero.UnmarshalJSON([]byte(`{}`)); err != errNotInitialized {
+		t.Fatalf("expect errNotInitialized but got %v", err)
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	m := NewString[account](WithShardCount(16))
+	defer m.Close()
+	for i := 0; i < 500; i++ {
+		m.Set(strconv.Itoa(i), account{Name: strconv.Itoa(i), Balance: int64(i)})
+	}
+	m.SetWithTTL("gone", account{}, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo: n=%d len=%d err=%v", n, buf.Len(), err)
+	}
+	size := buf.Len()
+	restored := NewString[account]()
+	if n, err := restored.ReadFrom(&buf); err != nil || n != int64(size) {
+		t.Fatalf("ReadFrom: n=%d size=%d err=%v", n, size, err)
+	}
+	if restored.Count() != 500 || restored.Has("gone") {
+		t.Fatalf("expect 500 elements but got %d", restored.Count())
+	}
+	if v, _ := restored.Get("42"); v.Balance != 42 {
+		t.Fatalf("unexpected value %+v", v)
+	}
+
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.UnmarshalBinary(b[:len(b)/2]); err == nil {
+		t.Fatal("expect error for truncated data")
+	}
+	empty := NewString[account]()
+	b, _ = empty.MarshalBinary()
+	if err := restored.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 编码的过程中Resize等待，元素不会丢
+func TestEncodeDuringResize(t *testing.T) {
+	m := New[int, int](IntegerHasher[int](), WithShardCount(4))
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, n := range []int{64, 3, 128, 7} {
+			m.Resize(n)
+		}
+	}()
+	for {
+		var buf bytes.Buffer
+		if _, err := m.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		restored := New[int, int](IntegerHasher[int]())
+		if _, err := restored.ReadFrom(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if restored.Count() != 1000 {
+			t.Fatalf("expect 1000 elements but got %d", restored.Count())
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	m := New[int, account](IntegerHasher[int]())
+	for i := 0; i < 10000; i++ {
+		m.Set(i, account{Name: strconv.Itoa(i), Balance: int64(i)})
+	}
+	b.Run("json", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			m.EncodeJSON(&buf)
+			b.SetBytes(int64(buf.Len()))
+		}
+	})
+	b.Run("binary", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			m.WriteTo(&buf)
+			b.SetBytes(int64(buf.Len()))
+		}
+	})
+}
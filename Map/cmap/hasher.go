@@ -0,0 +1,66 @@
+package cmap
+
+// Hasher 计算key的哈希值，用来选择分片。结构体等其它类型的key传入自己的哈希函数。
+type Hasher[K comparable] func(key K) uint32
+
+// Integer 可以使用IntegerHasher的key类型
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// StringHasher 字符串key的哈希函数，和原来的ConcurrentMap一样使用fnv32
+func StringHasher[K ~string]() Hasher[K] {
+	return func(key K) uint32 {
+		return fnv32(string(key))
+	}
+}
+
+// IntegerHasher 整数key的哈希函数。连续的整数直接取模会集中在相邻的分片上，
+// 所以先打散
+func IntegerHasher[K Integer]() Hasher[K] {
+	return func(key K) uint32 {
+		return mix64(uint64(key))
+	}
+}
+
+// BytesHasher 由字节序列得到的key的哈希函数，比如[16]byte的UUID，
+// 或者需要规范化之后再哈希的key。toBytes返回key的字节序列，对它计算fnv32。
+func BytesHasher[K comparable](toBytes func(key K) []byte) Hasher[K] {
+	return func(key K) uint32 {
+		return fnv32Bytes(toBytes(key))
+	}
+}
+
+const (
+	offset32 = uint32(2166136261)
+	prime32  = uint32(16777619)
+)
+
+func fnv32(key string) uint32 {
+	hash := offset32
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+func fnv32Bytes(key []byte) uint32 {
+	hash := offset32
+	for _, c := range key {
+		hash *= prime32
+		hash ^= uint32(c)
+	}
+	return hash
+}
+
+// mix64 MurmurHash3的fmix64
+func mix64(x uint64) uint32 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint32(x)
+}
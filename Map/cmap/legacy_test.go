@@ -0,0 +1,39 @@
+package cmap
+
+import "sync"
+
+// legacyMap 是Map/cmap_test.go中原来的ConcurrentMap，只保留了基准测试用到的部分。
+// 那里是_test.go文件，没法导入。
+type legacyMap []*legacyShard
+
+type legacyShard struct {
+	items map[string]interface{}
+	sync.RWMutex
+}
+
+func newLegacy() legacyMap {
+	m := make(legacyMap, DefaultShardCount)
+	for i := 0; i < DefaultShardCount; i++ {
+		m[i] = &legacyShard{items: make(map[string]interface{})}
+	}
+	return m
+}
+
+func (m legacyMap) GetShard(key string) *legacyShard {
+	return m[uint(fnv32(key))%uint(DefaultShardCount)]
+}
+
+func (m legacyMap) Set(key string, value interface{}) {
+	shard := m.GetShard(key)
+	shard.Lock()
+	shard.items[key] = value
+	shard.Unlock()
+}
+
+func (m legacyMap) Get(key string) (interface{}, bool) {
+	shard := m.GetShard(key)
+	shard.RLock()
+	val, ok := shard.items[key]
+	shard.RUnlock()
+	return val, ok
+}
@@ -0,0 +1,150 @@
+package cmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithShardCount(t *testing.T) {
+	m := NewString[int](WithShardCount(7))
+	if m.ShardCount() != 7 {
+		t.Fatalf("expect 7 shards but got %d", m.ShardCount())
+	}
+	if n := NewString[int]().ShardCount(); n != DefaultShardCount {
+		t.Fatalf("expect %d shards but got %d", DefaultShardCount, n)
+	}
+}
+
+func TestResize(t *testing.T) {
+	m := New[int, int](IntegerHasher[int](), WithShardCount(4))
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	for _, n := range []int{64, 3, 3, 128} {
+		m.Resize(n)
+		if m.ShardCount() != n {
+			t.Fatalf("expect %d shards but got %d", n, m.ShardCount())
+		}
+		if m.Count() != 1000 {
+			t.Fatalf("expect 1000 elements after resizing to %d but got %d", n, m.Count())
+		}
+		for i := 0; i < 1000; i++ {
+			if v, ok := m.Get(i); !ok || v != i {
+				t.Fatalf("key %d lost after resizing to %d", i, n)
+			}
+		}
+	}
+}
+
+// Resize的过程中，读写不会丢失元素，整个map的遍历也不会重复或者遗漏
+func TestResizeConcurrent(t *testing.T) {
+	const keys = 2000
+	m := New[int, int](IntegerHasher[int](), WithShardCount(2))
+	for i := 0; i < keys; i++ {
+		m.Set(i, 0)
+	}
+
+	var stop int32
+	var wg sync.WaitGroup
+	var incs [4]int
+	for w := range incs {
+		wg.Add(1)
+		go func(w int) { // 每个writer负责一部分key
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				for i := w; i < keys; i += len(incs) {
+					m.Upsert(i, 1, func(exist bool, v, n int) int {
+						if !exist {
+							t.Errorf("key %d lost during resize", i)
+						}
+						return v + n
+					})
+				}
+				incs[w]++
+			}
+		}(w)
+	}
+	for r := 0; r < 2; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				if n := m.Count(); n != keys {
+					t.Errorf("expect %d elements during resize but got %d", keys, n)
+				}
+				for i := 0; i < keys; i += 97 {
+					if !m.Has(i) {
+						t.Errorf("key %d not found during resize", i)
+					}
+				}
+			}
+		}()
+	}
+
+	for _, n := range []int{8, 64, 5, 256, 16} {
+		m.Resize(n)
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	for i := 0; i < keys; i++ {
+		if v, _ := m.Get(i); v != incs[i%len(incs)] {
+			t.Fatalf("key %d: expect %d increments but got %d", i, incs[i%len(incs)], v)
+		}
+	}
+}
+
+func BenchmarkResize(b *testing.B) {
+	m := New[int, int](IntegerHasher[int]())
+	for i := 0; i < 100000; i++ {
+		m.Set(i, i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%2 == 0 {
+			m.Resize(256)
+		} else {
+			m.Resize(DefaultShardCount)
+		}
+	}
+}
+
+// 遍历的回调中调用需要resizeMu读锁的方法，同时有Resize在等待，不会死锁
+func TestIterCbDuringResize(t *testing.T) {
+	m := New[int, int](IntegerHasher[int](), WithShardCount(4))
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	resized := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		first := true
+		m.IterCb(func(key, v int) {
+			if first {
+				first = false
+				go func() {
+					m.Resize(16)
+					close(resized)
+				}()
+				time.Sleep(10 * time.Millisecond) // 让Resize开始等待
+			}
+			if m.Count() != 100 || m.GetShard(key) == nil {
+				t.Errorf("unexpected map state during iteration")
+			}
+		})
+		m.Keys()
+		m.Snapshot()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock between IterCb and Resize")
+	}
+	<-resized
+	if m.ShardCount() != 16 || m.Count() != 100 {
+		t.Fatalf("expect 100 elements in 16 shards but got %d in %d", m.Count(), m.ShardCount())
+	}
+}
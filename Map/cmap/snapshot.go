@@ -0,0 +1,80 @@
+package cmap
+
+import "encoding/json"
+
+// Snapshot map在某一时刻的只读副本。
+//
+// IterCb、IterBuffered和Items一个分片一个分片地读，只在一个分片内是一致的：
+// 遍历的过程中其它分片还在被修改，两个key的值可能来自不同的时刻。
+// Snapshot看到的是整个map在同一时刻的状态，之后的修改不会影响它。
+type Snapshot[K comparable, V any] struct {
+	items map[K]V
+}
+
+// Snapshot 返回整个map在当前时刻的副本。
+//
+// 按固定的顺序给所有分片加读锁，全部锁住的那一刻就是快照的时刻；
+// 之后一边复制一边释放，复制完的分片马上可以写，不需要等所有分片都复制完。
+// 写操作一次只锁一个分片，所以按固定顺序加锁不会死锁。
+func (m *ConcurrentMap[K, V]) Snapshot() *Snapshot[K, V] {
+	shards, release := m.pinShards()
+	defer release()
+	n := 0
+	for _, shard := range shards {
+		shard.RLock()
+		n += len(shard.items)
+	}
+	now := nanotime()
+
+	items := make(map[K]V, n)
+	for _, shard := range shards {
+		for key, val := range shard.items {
+			if !shard.expiredAt(key, now) {
+				items[key] = val
+			}
+		}
+		shard.RUnlock()
+	}
+	return &Snapshot[K, V]{items: items}
+}
+
+// Count 元素的数量
+func (s *Snapshot[K, V]) Count() int {
+	return len(s.items)
+}
+
+// Get 返回key在快照时刻的值
+func (s *Snapshot[K, V]) Get(key K) (V, bool) {
+	v, ok := s.items[key]
+	return v, ok
+}
+
+// IterCb 用回调函数遍历所有元素
+func (s *Snapshot[K, V]) IterCb(fn IterCb[K, V]) {
+	for key, val := range s.items {
+		fn(key, val)
+	}
+}
+
+// Keys 返回所有的key
+func (s *Snapshot[K, V]) Keys() []K {
+	keys := make([]K, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Items 以map[K]V返回所有元素，返回的是一个新的map，可以修改
+func (s *Snapshot[K, V]) Items() map[K]V {
+	items := make(map[K]V, len(s.items))
+	for key, val := range s.items {
+		items[key] = val
+	}
+	return items
+}
+
+// MarshalJSON 把快照编码为一个JSON对象
+func (s *Snapshot[K, V]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.items)
+}
@@ -0,0 +1,111 @@
+package cmap
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	m := New[int, int](IntegerHasher[int]())
+	defer m.Close()
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	m.SetWithTTL(100, 100, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+
+	s := m.Snapshot()
+	m.Set(0, -1)
+	m.Remove(1)
+	m.Set(200, 200)
+	if s.Count() != 100 {
+		t.Fatalf("expect 100 elements but got %d", s.Count())
+	}
+	if v, ok := s.Get(0); !ok || v != 0 {
+		t.Fatalf("snapshot changed by later writes: %v, %v", v, ok)
+	}
+	if _, ok := s.Get(100); ok {
+		t.Fatal("expired key should not be in snapshot")
+	}
+	if !m.Has(200) || m.Has(1) {
+		t.Fatal("map should not be affected by snapshot")
+	}
+	items := s.Items()
+	items[0] = 42
+	if v, _ := s.Get(0); v != 0 {
+		t.Fatal("Items should return a copy")
+	}
+	if len(s.Keys()) != 100 {
+		t.Fatalf("unexpected keys %v", s.Keys())
+	}
+}
+
+// 一个writer按key的顺序把所有key写成同一代，快照应该看到某一代的前一部分加上一代的后一部分，
+// 也就是值随key不增并且最多差1。一个分片一个分片地遍历做不到这一点。
+func TestSnapshotConsistent(t *testing.T) {
+	const keys = 256
+	m := New[int, int](IntegerHasher[int](), WithShardCount(16))
+	for i := 0; i < keys; i++ {
+		m.Set(i, 0)
+	}
+	var stop int32
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for gen := 1; atomic.LoadInt32(&stop) == 0; gen++ {
+			for i := 0; i < keys; i++ {
+				m.Set(i, gen)
+			}
+		}
+	}()
+
+	for n := 0; n < 200; n++ {
+		s := m.Snapshot()
+		if s.Count() != keys {
+			t.Fatalf("expect %d elements but got %d", keys, s.Count())
+		}
+		first, _ := s.Get(0)
+		prev := first
+		for i := 1; i < keys; i++ {
+			v, _ := s.Get(i)
+			if v > prev || first-v > 1 {
+				t.Fatalf("inconsistent snapshot: key %d is %d, key %d is %d, key 0 is %d", i-1, prev, i, v, first)
+			}
+			prev = v
+		}
+		if _, err := json.Marshal(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+}
+
+// Resize的过程中快照也是完整的
+func TestSnapshotDuringResize(t *testing.T) {
+	m := New[int, int](IntegerHasher[int](), WithShardCount(2))
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, n := range []int{8, 64, 5, 256, 16} {
+			m.Resize(n)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if n := m.Snapshot().Count(); n != 1000 {
+			t.Fatalf("expect 1000 elements but got %d", n)
+		}
+	}
+}
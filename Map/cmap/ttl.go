@@ -0,0 +1,237 @@
+package cmap
+
+import (
+	"sync"
+	"time"
+)
+
+// EvictReason 元素被自动移除的原因
+type EvictReason int
+
+const (
+	EvictExpired  EvictReason = iota + 1 // 存活时间到期
+	EvictCapacity                        // 超过了WithMaxEntries的数量限制
+	EvictCost                            // 超过了WithMaxCost的代价限制
+
+	numEvictReasons = iota
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	case EvictCost:
+		return "cost"
+	}
+	return "unknown"
+}
+
+// EvictCb 元素被自动移除时调用。调用时不持有任何锁，可以访问这个map。
+type EvictCb[K comparable, V any] func(key K, val V, reason EvictReason)
+
+// WithEvictCallback 设置元素被自动移除时的回调，K和V要和map的类型一致
+func WithEvictCallback[K comparable, V any](fn EvictCb[K, V]) Option {
+	return func(o *options) {
+		o.onEvict = fn
+	}
+}
+
+// DefaultSweepInterval 默认清理过期元素的间隔
+const DefaultSweepInterval = time.Minute
+
+// 清理时每次持有写锁最多删除的元素数量
+const sweepBatch = 64
+
+// WithSweepInterval 设置后台清理过期元素的间隔，默认是DefaultSweepInterval
+func WithSweepInterval(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.sweepInterval = d
+		}
+	}
+}
+
+func nanotime() int64 {
+	return time.Now().UnixNano()
+}
+
+// SetWithTTL 设置key的值，ttl之后过期。ttl<=0表示不会过期，和Set一样。
+// 过期的元素立即不可见，由后台的清理goroutine删除；第一次调用时启动清理goroutine，
+// 不再使用map时必须调用Close停止它。
+func (m *ConcurrentMap[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		m.Set(key, value)
+		return
+	}
+	m.startJanitor()
+	shard := m.lock(key)
+	m.put(shard, key, value)
+	if _, ok := shard.items[key]; ok { // 可能因为代价太大被立即淘汰了
+		if shard.expires == nil {
+			shard.expires = make(map[K]int64)
+		}
+		shard.expires[key] = nanotime() + int64(ttl)
+	}
+	m.unlock(shard)
+}
+
+// TTL 返回key剩余的存活时间，key没有设置过期时间时ttl为0
+func (m *ConcurrentMap[K, V]) TTL(key K) (ttl time.Duration, ok bool) {
+	shard := m.rlock(key)
+	defer shard.RUnlock()
+	if _, ok = shard.items[key]; !ok {
+		return 0, false
+	}
+	e, has := shard.expires[key]
+	if !has {
+		return 0, true
+	}
+	if ttl = time.Duration(e - nanotime()); ttl <= 0 {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// expired key是否已经过期，需要持有读锁
+func (s *Shard[K, V]) expired(key K) bool {
+	if len(s.expires) == 0 {
+		return false
+	}
+	return s.expiredAt(key, nanotime())
+}
+
+// expiredAt key在now时是否已经过期，需要持有读锁。
+// 遍历分片时用同一个now，看到的是同一时刻的过期状态。
+func (s *Shard[K, V]) expiredAt(key K, now int64) bool {
+	e, ok := s.expires[key]
+	return ok && e <= now
+}
+
+// removeExpired key已经过期时删除它，释放写锁时调用回调，需要持有写锁
+func (s *Shard[K, V]) removeExpired(key K) bool {
+	if !s.expired(key) {
+		return false
+	}
+	s.record(key, s.items[key], EvictExpired)
+	s.del(key)
+	return true
+}
+
+// expire Get和Has发现key已经过期时调用，在写锁下删除它，不用等后台的清理。
+// 释放读锁之后可能已经被重新设置了，removeExpired会再检查一次。
+func (m *ConcurrentMap[K, V]) expire(key K) {
+	shard := m.lock(key)
+	shard.removeExpired(key)
+	m.unlock(shard)
+}
+
+// evict 调用淘汰回调，不能持有锁
+func (m *ConcurrentMap[K, V]) evict(key K, val V, reason EvictReason) {
+	if m.onEvict != nil {
+		m.onEvict(key, val, reason)
+	}
+}
+
+// janitor 每个map一个清理过期元素的goroutine，依次清理所有分片
+type janitor struct {
+	mu      sync.Mutex
+	started bool
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// startJanitor 启动清理goroutine，已经启动或者已经Close时什么也不做
+func (m *ConcurrentMap[K, V]) startJanitor() {
+	j := &m.janitor
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.started || j.closed {
+		return
+	}
+	j.started = true
+	j.done = make(chan struct{})
+	j.wg.Add(1)
+	go m.runJanitor(j.done)
+}
+
+// runJanitor 每隔sweepInterval依次清理当前的所有分片。
+// 每次重新取分片列表，Resize之后自然清理新的分片。
+func (m *ConcurrentMap[K, V]) runJanitor(done chan struct{}) {
+	defer m.janitor.wg.Done()
+	ticker := time.NewTicker(m.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		m.resizeMu.RLock()
+		shards := m.shards()
+		m.resizeMu.RUnlock()
+		for _, s := range shards {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			m.sweep(s)
+		}
+	}
+}
+
+// sweep 删除分片中过期的元素，分片已经迁移时什么也不做。
+// 先在读锁下找出过期的key，再分批在写锁下删除，不会长时间持有写锁。
+func (m *ConcurrentMap[K, V]) sweep(s *Shard[K, V]) {
+	s.RLock()
+	if s.migrated {
+		s.RUnlock()
+		return
+	}
+	now := nanotime()
+	var keys []K
+	for key, e := range s.expires {
+		if e <= now {
+			keys = append(keys, key)
+		}
+	}
+	s.RUnlock()
+
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > sweepBatch {
+			batch = batch[:sweepBatch]
+		}
+		keys = keys[len(batch):]
+
+		s.Lock()
+		if s.migrated {
+			s.Unlock()
+			return
+		}
+		for _, key := range batch {
+			s.removeExpired(key) // 期间可能已经被重新设置了
+		}
+		m.unlock(s)
+	}
+}
+
+// Close 停止后台的清理goroutine。之后map还可以使用，但是过期的元素不会再被自动删除。
+// 调用过SetWithTTL的map不再使用时必须调用Close，清理goroutine引用着map，
+// 不调用的话goroutine和map都不会被回收。
+func (m *ConcurrentMap[K, V]) Close() error {
+	j := &m.janitor
+	j.mu.Lock()
+	if !j.closed {
+		j.closed = true
+		if j.started {
+			close(j.done)
+		}
+	}
+	j.mu.Unlock()
+	j.wg.Wait()
+	return nil
+}
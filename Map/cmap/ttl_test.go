@@ -0,0 +1,168 @@
+package cmap
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetWithTTL(t *testing.T) {
+	m := NewString[int]()
+	defer m.Close()
+	m.SetWithTTL("a", 1, 20*time.Millisecond)
+	m.SetWithTTL("b", 2, time.Hour)
+	m.Set("c", 3)
+	if ttl, ok := m.TTL("a"); !ok || ttl <= 0 || ttl > 20*time.Millisecond {
+		t.Fatalf("unexpected ttl %v, %v", ttl, ok)
+	}
+	if ttl, ok := m.TTL("c"); !ok || ttl != 0 {
+		t.Fatalf("expect no ttl for c but got %v, %v", ttl, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := m.Get("a"); ok || m.Has("a") {
+		t.Fatal("expired key should not be visible")
+	}
+	if _, ok := m.TTL("a"); ok {
+		t.Fatal("expired key should have no ttl")
+	}
+	if m.Count() != 2 {
+		t.Fatalf("expect 2 live elements but got %d", m.Count())
+	}
+	if keys := m.Keys(); len(keys) != 2 {
+		t.Fatalf("expect 2 keys but got %v", keys)
+	}
+	if items := m.Items(); len(items) != 2 || items["b"] != 2 {
+		t.Fatalf("unexpected items %v", items)
+	}
+
+	// 过期的元素当作不存在
+	if !m.SetIfAbsent("a", 10) {
+		t.Fatal("SetIfAbsent on expired key should succeed")
+	}
+	if v, _ := m.Get("a"); v != 10 {
+		t.Fatalf("expect 10 but got %d", v)
+	}
+	if ttl, _ := m.TTL("a"); ttl != 0 {
+		t.Fatalf("expect new value to have no ttl but got %v", ttl)
+	}
+
+	// Set清除过期时间，Upsert保留过期时间
+	m.Upsert("b", 5, func(exist bool, v, n int) int { return v + n })
+	if ttl, _ := m.TTL("b"); ttl <= 0 {
+		t.Fatal("Upsert should keep the ttl")
+	}
+	m.Set("b", 2)
+	if ttl, _ := m.TTL("b"); ttl != 0 {
+		t.Fatal("Set should clear the ttl")
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	var mu sync.Mutex
+	evicted := make(map[string]EvictReason)
+	m := NewString[int](
+		WithShardCount(4),
+		WithSweepInterval(5*time.Millisecond),
+		WithEvictCallback(func(key string, v int, reason EvictReason) {
+			mu.Lock()
+			evicted[key] = reason
+			mu.Unlock()
+		}),
+	)
+	defer m.Close()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		m.SetWithTTL(key, 1, 10*time.Millisecond)
+	}
+	m.SetWithTTL("f", 1, time.Hour)
+	m.Resize(8) // 迁移之后新的分片也会被清理
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(evicted)
+		mu.Unlock()
+		if n == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect 5 evictions but got %v", evicted)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for key, reason := range evicted {
+		if reason != EvictExpired {
+			t.Fatalf("unexpected reason %v for %s", reason, key)
+		}
+	}
+	m.resizeMu.RLock()
+	total := 0
+	for _, s := range m.shards() {
+		s.RLock()
+		total += len(s.items) + len(s.expires)
+		s.RUnlock()
+	}
+	m.resizeMu.RUnlock()
+	if total != 2 { // f的值和过期时间
+		t.Fatalf("expect expired entries to be deleted, %d left", total)
+	}
+}
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	m := NewString[int](WithShardCount(16))
+	m.SetWithTTL("a", 1, time.Hour)
+	if n := runtime.NumGoroutine(); n != before+1 {
+		t.Fatalf("expect one janitor for 16 shards, got %d goroutines (was %d)", n, before)
+	}
+	m.Close()
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("expect janitors to stop, %d goroutines left (was %d)", n, before)
+	}
+	m.SetWithTTL("b", 1, time.Hour) // Close之后不再启动
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("janitor restarted after Close")
+	}
+}
+
+func TestEvictCallbackTypeMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic")
+		}
+	}()
+	NewString[int](WithEvictCallback(func(key int, v int, reason EvictReason) {}))
+}
+
+// Get和Has发现过期的元素时立即删除它，不等后台清理
+func TestExpireOnAccess(t *testing.T) {
+	var evicted []string
+	m := NewString[int](WithEvictCallback(func(key string, v int, reason EvictReason) {
+		evicted = append(evicted, key)
+	}))
+	defer m.Close()
+	m.SetWithTTL("a", 1, time.Millisecond)
+	m.SetWithTTL("b", 2, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := m.Get("a"); ok {
+		t.Fatal("expired key should not be visible")
+	}
+	if m.Has("b") {
+		t.Fatal("expired key should not be visible")
+	}
+	m.resizeMu.RLock()
+	left := 0
+	for _, s := range m.shards() {
+		s.RLock()
+		left += len(s.items) + len(s.expires)
+		s.RUnlock()
+	}
+	m.resizeMu.RUnlock()
+	if left != 0 || len(evicted) != 2 {
+		t.Fatalf("expect expired keys to be deleted on access, %d left, evicted %v", left, evicted)
+	}
+	if st := m.Stats(); st.Evictions[EvictExpired] != 2 {
+		t.Fatalf("expect 2 expirations but got %+v", st)
+	}
+}
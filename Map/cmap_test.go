@@ -9,6 +9,7 @@ var SHARD_COUNT = 32 //默认分为32片
 
 // A "thread" safe map of type string:Anything.
 // To avoid lock bottlenecks this map is dived to several (SHARD_COUNT) map shards.
+// 泛型的版本见 Map/cmap 包。
 type ConcurrentMap []*ConcurrentMapShared
 
 // A "thread" safe string to anything map.
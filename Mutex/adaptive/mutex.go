@@ -0,0 +1,214 @@
+// Package adaptive 提供先自旋、再休眠的自适应互斥锁。
+//
+// sync.Mutex的自旋依赖runtime_canSpin/runtime_doSpin，用户代码用不了，
+// 并且自旋次数是固定的。这里的Mutex会采样统计最近的持有时间（指数加权平均），
+// 据此决定自旋多久：持有时间短，自旋等一会儿很可能就拿到锁了；持有时间长，
+// 自旋只是浪费CPU，直接休眠。自旋期间按指数退避的方式检查锁是否被释放，
+// 超过预算之后在channel上休眠，被唤醒后重新和其它goroutine竞争。
+//
+// 它不保证公平，需要公平的场景使用fairlock包。
+package adaptive
+
+import (
+	"container/list"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	mutexLocked  = 1 << iota // 锁被持有
+	mutexWaiters             // 有休眠的waiter，解锁需要走慢路径
+)
+
+const (
+	minSpin    = time.Microsecond      // 还没有统计到持有时间时的自旋预算
+	maxSpin    = 50 * time.Microsecond // 平均持有时间超过它就不自旋了
+	maxBackoff = 64                    // 退避时每轮最多空转的次数
+	sampleRate = 8                     // 每8次加锁采样一次持有时间
+	ewmaShift  = 3                     // 指数加权平均的权重 1/8
+)
+
+// Mutex 自适应的互斥锁，零值可用
+type Mutex struct {
+	acquires uint64
+	spins    uint64 // 自旋期间获取到锁的次数
+	parks    uint64 // 休眠的次数
+
+	state      int32
+	holdNs     int64 // 持有时间的指数加权平均，纳秒
+	acquiredAt int64 // 采样的这次加锁的时间，只有持有者读写
+
+	mu      sync.Mutex
+	waiters list.List // chan struct{}
+}
+
+var spinSink int32
+
+func doSpin(n int) {
+	for i := 0; i < n; i++ {
+		atomic.LoadInt32(&spinSink)
+	}
+}
+
+func nanotime() int64 {
+	return time.Now().UnixNano()
+}
+
+// Lock 请求锁
+func (m *Mutex) Lock() {
+	// Fast path: 没有竞争
+	if !atomic.CompareAndSwapInt32(&m.state, 0, mutexLocked) {
+		m.lockSlow()
+	}
+	if atomic.AddUint64(&m.acquires, 1)%sampleRate == 0 {
+		m.acquiredAt = nanotime()
+	}
+}
+
+// TryLock 尝试获取锁，不会阻塞
+func (m *Mutex) TryLock() bool {
+	for {
+		old := atomic.LoadInt32(&m.state)
+		if old&mutexLocked != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&m.state, old, old|mutexLocked) {
+			atomic.AddUint64(&m.acquires, 1)
+			return true
+		}
+	}
+}
+
+// spinBudget 根据最近的持有时间决定自旋多久
+func (m *Mutex) spinBudget() time.Duration {
+	if runtime.GOMAXPROCS(0) == 1 { // 单核时持有者得不到运行，自旋没有意义
+		return 0
+	}
+	hold := time.Duration(atomic.LoadInt64(&m.holdNs))
+	if hold > maxSpin {
+		return 0
+	}
+	if budget := 2 * hold; budget > minSpin {
+		return budget
+	}
+	return minSpin
+}
+
+// spin 指数退避地自旋，在预算内获取到锁返回true
+func (m *Mutex) spin(budget time.Duration) bool {
+	if budget <= 0 {
+		return false
+	}
+	deadline := nanotime() + int64(budget)
+	for backoff := 1; ; {
+		if old := atomic.LoadInt32(&m.state); old&mutexLocked == 0 &&
+			atomic.CompareAndSwapInt32(&m.state, old, old|mutexLocked) {
+			atomic.AddUint64(&m.spins, 1)
+			return true
+		}
+		if nanotime() > deadline {
+			return false
+		}
+		doSpin(backoff)
+		if backoff < maxBackoff {
+			backoff <<= 1
+		}
+	}
+}
+
+func (m *Mutex) lockSlow() {
+	requeue := false
+	for {
+		if m.spin(m.spinBudget()) {
+			return
+		}
+
+		m.mu.Lock()
+		for {
+			old := atomic.LoadInt32(&m.state)
+			if old&mutexLocked == 0 { // 锁被释放了，再抢一次
+				if atomic.CompareAndSwapInt32(&m.state, old, old|mutexLocked) {
+					m.mu.Unlock()
+					return
+				}
+				continue
+			}
+			// 设置waiter标记，让Unlock走慢路径唤醒我们
+			if atomic.CompareAndSwapInt32(&m.state, old, old|mutexWaiters) {
+				break
+			}
+		}
+		ch := make(chan struct{}, 1)
+		if requeue { // 被唤醒后没抢到锁的排到队头
+			m.waiters.PushFront(ch)
+		} else {
+			m.waiters.PushBack(ch)
+		}
+		m.mu.Unlock()
+
+		atomic.AddUint64(&m.parks, 1)
+		<-ch
+		requeue = true
+	}
+}
+
+// Unlock 释放锁
+func (m *Mutex) Unlock() {
+	if at := m.acquiredAt; at != 0 { // 这次加锁被采样了，更新平均持有时间
+		m.acquiredAt = 0
+		old := atomic.LoadInt64(&m.holdNs)
+		atomic.StoreInt64(&m.holdNs, old+(nanotime()-at-old)>>ewmaShift)
+	}
+
+	// Fast path: 没有休眠的waiter
+	if atomic.CompareAndSwapInt32(&m.state, mutexLocked, 0) {
+		return
+	}
+	m.unlockSlow()
+}
+
+func (m *Mutex) unlockSlow() {
+	m.mu.Lock()
+	// 持有mu并且锁被持有的时候，只有我们会修改state
+	old := atomic.LoadInt32(&m.state)
+	if old&mutexLocked == 0 {
+		m.mu.Unlock()
+		panic("adaptive: unlock of unlocked mutex")
+	}
+	e := m.waiters.Front()
+	if e == nil {
+		atomic.StoreInt32(&m.state, 0)
+		m.mu.Unlock()
+		return
+	}
+	ch := m.waiters.Remove(e).(chan struct{})
+	if m.waiters.Len() == 0 {
+		atomic.StoreInt32(&m.state, 0)
+	} else {
+		atomic.StoreInt32(&m.state, mutexWaiters)
+	}
+	m.mu.Unlock()
+	ch <- struct{}{} // 唤醒队头的waiter去竞争
+}
+
+// Stats 统计信息
+type Stats struct {
+	Acquires     uint64        // 获取锁的次数
+	SpinAcquires uint64        // 自旋期间获取到锁的次数
+	Parks        uint64        // 休眠的次数
+	AvgHold      time.Duration // 采样的平均持有时间
+	SpinBudget   time.Duration // 当前的自旋预算
+}
+
+// Stats 返回统计信息
+func (m *Mutex) Stats() Stats {
+	return Stats{
+		Acquires:     atomic.LoadUint64(&m.acquires),
+		SpinAcquires: atomic.LoadUint64(&m.spins),
+		Parks:        atomic.LoadUint64(&m.parks),
+		AvgHold:      time.Duration(atomic.LoadInt64(&m.holdNs)),
+		SpinBudget:   m.spinBudget(),
+	}
+}
@@ -0,0 +1,189 @@
+package adaptive
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"GoConcurrentProgramming/Mutex/fairlock"
+)
+
+var _ sync.Locker = (*Mutex)(nil)
+
+func TestCounter(t *testing.T) {
+	var m Mutex
+	var count int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				m.Lock()
+				count++
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 100000 {
+		t.Fatalf("expect 100000 but got %d", count)
+	}
+	if s := m.Stats(); s.Acquires != 100000 {
+		t.Fatalf("expect 100000 acquires but got %d", s.Acquires)
+	}
+}
+
+func TestTryLock(t *testing.T) {
+	var m Mutex
+	if !m.TryLock() {
+		t.Fatal("TryLock on unlocked mutex should succeed")
+	}
+	if m.TryLock() {
+		t.Fatal("TryLock on locked mutex should fail")
+	}
+	m.Unlock()
+	if !m.TryLock() {
+		t.Fatal("TryLock after Unlock should succeed")
+	}
+	m.Unlock()
+}
+
+func TestUnlockOfUnlocked(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic")
+		}
+	}()
+	var m Mutex
+	m.Unlock()
+}
+
+// 持有时间很长时，等待者不再自旋，直接休眠
+func TestLongHoldParks(t *testing.T) {
+	if runtime.GOMAXPROCS(0) == 1 {
+		t.Skip("no spinning with GOMAXPROCS=1")
+	}
+	var m Mutex
+	for i := 0; i < 8*sampleRate; i++ {
+		m.Lock()
+		time.Sleep(200 * time.Microsecond)
+		m.Unlock()
+	}
+	s := m.Stats()
+	if s.AvgHold <= maxSpin {
+		t.Fatalf("expect avg hold > %v but got %v", maxSpin, s.AvgHold)
+	}
+	if s.SpinBudget != 0 {
+		t.Fatalf("expect no spin budget but got %v", s.SpinBudget)
+	}
+
+	m.Lock()
+	done := make(chan struct{})
+	go func() {
+		m.Lock()
+		m.Unlock()
+		close(done)
+	}()
+	for m.Stats().Parks == 0 {
+		runtime.Gosched()
+	}
+	m.Unlock()
+	<-done
+	if s := m.Stats(); s.SpinAcquires != 0 {
+		t.Fatalf("expect no spin acquires but got %d", s.SpinAcquires)
+	}
+}
+
+// 持有时间很短时，等待者通过自旋获取到锁
+func TestShortHoldSpins(t *testing.T) {
+	if runtime.GOMAXPROCS(0) < 2 {
+		t.Skip("need GOMAXPROCS >= 2")
+	}
+	var m Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				m.Lock()
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	s := m.Stats()
+	if s.SpinBudget == 0 {
+		t.Fatalf("expect a spin budget, avg hold %v", s.AvgHold)
+	}
+	t.Logf("%+v", s)
+}
+
+// chanMutex 和Channel/chan_mutex.go中的Mutex一样，那里是main包，没法导入
+type chanMutex struct {
+	ch chan struct{}
+}
+
+func newChanMutex() *chanMutex {
+	mu := &chanMutex{make(chan struct{}, 1)}
+	mu.ch <- struct{}{}
+	return mu
+}
+
+func (m *chanMutex) Lock() {
+	<-m.ch
+}
+
+func (m *chanMutex) Unlock() {
+	select {
+	case m.ch <- struct{}{}:
+	default:
+		panic("unlock of unlocked mutex")
+	}
+}
+
+var sink int
+
+// work 模拟临界区，n次简单的计算
+func work(x, n int) int {
+	for i := 0; i < n; i++ {
+		x = x*31 + i
+	}
+	return x
+}
+
+// BenchmarkLocks 在不同的GOMAXPROCS和临界区长度下比较各种锁，
+// 例如 go test -bench Locks -run ^$ ./Mutex/adaptive
+func BenchmarkLocks(b *testing.B) {
+	locks := []struct {
+		name string
+		new  func() sync.Locker
+	}{
+		{"sync.Mutex", func() sync.Locker { return &sync.Mutex{} }},
+		{"chan", func() sync.Locker { return newChanMutex() }},
+		{"ticket", func() sync.Locker { return &fairlock.TicketLock{} }},
+		{"adaptive", func() sync.Locker { return &Mutex{} }},
+	}
+	for _, procs := range []int{1, 2, 4, 8} {
+		for _, cs := range []int{0, 10, 100, 1000} {
+			for _, l := range locks {
+				b.Run(fmt.Sprintf("procs=%d/cs=%d/%s", procs, cs, l.name), func(b *testing.B) {
+					defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+					mu := l.new()
+					b.RunParallel(func(pb *testing.PB) {
+						local := 0
+						for pb.Next() {
+							mu.Lock()
+							sink = work(sink, cs)
+							mu.Unlock()
+							local = work(local, cs) // 临界区外也做同样多的事情
+						}
+					})
+				})
+			}
+		}
+	}
+}
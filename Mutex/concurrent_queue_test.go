@@ -2,6 +2,7 @@ package Mutex
 
 import "sync"
 
+// SliceQueue 用Mutex保护的简单队列，有界、阻塞、泛型的版本见 queue 包
 type SliceQueue struct {
 	data []interface{}
 	mu   sync.Mutex
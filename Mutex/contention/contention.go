@@ -0,0 +1,236 @@
+// Package contention 提供带统计的锁，用来找出线上最“热”的锁。
+//
+// 每把命名的锁都会记录请求锁的等待时间和持有锁的时间两个直方图，
+// 以及遇到饥饿模式的次数。sync.Mutex的waiter等待超过1毫秒（starvationThresholdNs）
+// 之后会把锁切换到饥饿模式，这时直接把锁交给等待队列中的第一个waiter。
+// 和IsStarving一样通过unsafe读取state中的mutexStarving位，只有包装的是*sync.Mutex时才能统计。
+package contention
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+	"unsafe"
+)
+
+// sync.Mutex的state中表示饥饿模式的位
+const mutexStarving = 1 << 2
+
+// 当前Go版本的sync.Mutex的第一个字段是不是state，不是的话不统计饥饿模式
+var stateSupported = checkState()
+
+func checkState() bool {
+	var mu sync.Mutex
+	if mutexState(&mu) != 0 {
+		return false
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return mutexState(&mu) == 1 // mutexLocked
+}
+
+func mutexState(mu *sync.Mutex) int32 {
+	return atomic.LoadInt32((*int32)(unsafe.Pointer(mu)))
+}
+
+func starving(mu *sync.Mutex) bool {
+	return mutexState(mu)&mutexStarving != 0
+}
+
+// Observer 接收加锁的事件，其它锁的实现也可以通过它接入统计
+type Observer interface {
+	// Acquired 获取到了锁，wait是请求锁花费的时间
+	Acquired(wait time.Duration)
+	// Released 释放了锁，hold是持有锁的时间
+	Released(hold time.Duration)
+}
+
+// StarvationObserver 可以额外实现的接口，接收饥饿模式的事件
+type StarvationObserver interface {
+	// Starving 这次加锁时sync.Mutex处于饥饿模式
+	Starving()
+}
+
+// Stats 一把锁的统计信息，实现了Observer
+type Stats struct {
+	name       string
+	starvation uint64 // 遇到饥饿模式的加锁次数
+	wait       Histogram
+	hold       Histogram
+}
+
+// Name 锁的名字
+func (s *Stats) Name() string {
+	return s.name
+}
+
+// Acquired 记录一次等待
+func (s *Stats) Acquired(wait time.Duration) {
+	s.wait.Observe(wait)
+}
+
+// Starving 记录一次遇到饥饿模式的加锁
+func (s *Stats) Starving() {
+	atomic.AddUint64(&s.starvation, 1)
+}
+
+// Released 记录一次持有
+func (s *Stats) Released(hold time.Duration) {
+	s.hold.Observe(hold)
+}
+
+// Snapshot 返回统计信息的副本
+func (s *Stats) Snapshot() Snapshot {
+	return Snapshot{
+		Name:       s.name,
+		Starvation: atomic.LoadUint64(&s.starvation),
+		Wait:       s.wait.Snapshot(),
+		Hold:       s.hold.Snapshot(),
+	}
+}
+
+// Snapshot 某一时刻一把锁的统计信息
+type Snapshot struct {
+	Name       string
+	Starvation uint64            // 请求锁或者获取到锁时sync.Mutex处于饥饿模式的次数
+	Wait       HistogramSnapshot // 请求锁的等待时间
+	Hold       HistogramSnapshot // 持有锁的时间
+}
+
+// Locker 包装一个sync.Locker，记录等待时间和持有时间
+type Locker struct {
+	l          sync.Locker
+	obs        Observer
+	mu         *sync.Mutex        // l是*sync.Mutex并且obs统计饥饿模式时不为nil
+	starving   StarvationObserver // 和obs是同一个对象
+	acquiredAt time.Time          // 只在持有锁的时候读写
+}
+
+// Wrap 用obs统计l的加锁情况。l是*sync.Mutex并且obs实现了StarvationObserver时，
+// 还会统计饥饿模式。
+func Wrap(l sync.Locker, obs Observer) *Locker {
+	lk := &Locker{l: l, obs: obs}
+	if mu, ok := l.(*sync.Mutex); ok && stateSupported {
+		if so, ok := obs.(StarvationObserver); ok {
+			lk.mu, lk.starving = mu, so
+		}
+	}
+	return lk
+}
+
+// Lock 请求锁并记录等待时间。
+// 请求锁之前和获取到锁之后各读一次饥饿位：饥饿模式下获取到锁的waiter在它不是最后一个waiter、
+// 并且自己也等待了超过1ms时不会清除这个位，所以获取到锁之后还能看到。
+func (l *Locker) Lock() {
+	start := time.Now()
+	starved := l.mu != nil && starving(l.mu)
+	l.l.Lock()
+	now := time.Now()
+	l.acquiredAt = now
+	if l.mu != nil && (starved || starving(l.mu)) {
+		l.starving.Starving()
+	}
+	l.obs.Acquired(now.Sub(start))
+}
+
+// Unlock 记录持有时间并释放锁
+func (l *Locker) Unlock() {
+	hold := time.Since(l.acquiredAt)
+	l.l.Unlock()
+	l.obs.Released(hold)
+}
+
+// Profiler 按名字管理锁的统计信息
+type Profiler struct {
+	mu    sync.Mutex
+	stats map[string]*Stats
+}
+
+// NewProfiler 创建一个Profiler
+func NewProfiler() *Profiler {
+	return &Profiler{stats: make(map[string]*Stats)}
+}
+
+// DefaultProfiler 包级函数使用的Profiler
+var DefaultProfiler = NewProfiler()
+
+// Stats 返回name对应的统计信息，不存在则创建。同名的锁共享一份统计。
+func (p *Profiler) Stats(name string) *Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.stats[name]
+	if !ok {
+		s = &Stats{name: name}
+		p.stats[name] = s
+	}
+	return s
+}
+
+// Locker 返回一个以name统计的l
+func (p *Profiler) Locker(name string, l sync.Locker) *Locker {
+	return Wrap(l, p.Stats(name))
+}
+
+// Mutex 返回一个以name统计的新的互斥锁
+func (p *Profiler) Mutex(name string) *Locker {
+	return p.Locker(name, &sync.Mutex{})
+}
+
+// Snapshots 返回所有锁的统计信息
+func (p *Profiler) Snapshots() []Snapshot {
+	p.mu.Lock()
+	all := make([]*Stats, 0, len(p.stats))
+	for _, s := range p.stats {
+		all = append(all, s)
+	}
+	p.mu.Unlock()
+
+	snaps := make([]Snapshot, 0, len(all))
+	for _, s := range all {
+		snaps = append(snaps, s.Snapshot())
+	}
+	return snaps
+}
+
+// Top 返回总等待时间最长的n把锁，n<=0返回全部
+func (p *Profiler) Top(n int) []Snapshot {
+	snaps := p.Snapshots()
+	sort.Slice(snaps, func(i, j int) bool {
+		if snaps[i].Wait.Sum != snaps[j].Wait.Sum {
+			return snaps[i].Wait.Sum > snaps[j].Wait.Sum
+		}
+		return snaps[i].Name < snaps[j].Name
+	})
+	if n > 0 && n < len(snaps) {
+		snaps = snaps[:n]
+	}
+	return snaps
+}
+
+// WriteReport 把最热的n把锁输出成一个表格
+func (p *Profiler) WriteReport(w io.Writer, n int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "name\tacquires\ttotal wait\tavg wait\tp99 wait\tmax wait\tavg hold\tp99 hold\tmax hold\tstarving\t")
+	for _, s := range p.Top(n) {
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%d\t\n",
+			s.Name, s.Wait.Count,
+			s.Wait.Sum, s.Wait.Mean(), s.Wait.Quantile(0.99), s.Wait.Max,
+			s.Hold.Mean(), s.Hold.Quantile(0.99), s.Hold.Max,
+			s.Starvation)
+	}
+	return tw.Flush()
+}
+
+// NewMutex 在DefaultProfiler中创建一个以name统计的互斥锁
+func NewMutex(name string) *Locker {
+	return DefaultProfiler.Mutex(name)
+}
+
+// WriteReport 输出DefaultProfiler中最热的n把锁
+func WriteReport(w io.Writer, n int) error {
+	return DefaultProfiler.WriteReport(w, n)
+}
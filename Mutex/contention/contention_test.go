@@ -0,0 +1,106 @@
+package contention
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	var h Histogram
+	for i := 0; i < 99; i++ {
+		h.Observe(time.Microsecond)
+	}
+	h.Observe(time.Second)
+
+	s := h.Snapshot()
+	if s.Count != 100 || s.Max != time.Second {
+		t.Fatalf("unexpected snapshot: count=%d max=%v", s.Count, s.Max)
+	}
+	if p50 := s.Quantile(0.5); p50 < time.Microsecond || p50 >= 2*time.Microsecond {
+		t.Fatalf("expect p50 about 1µs but got %v", p50)
+	}
+	if p999 := s.Quantile(0.999); p999 != time.Second {
+		t.Fatalf("expect p99.9 = 1s but got %v", p999)
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	p := NewProfiler()
+	hot := p.Mutex("hot")
+	cold := p.Mutex("cold")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				hot.Lock()
+				time.Sleep(2 * time.Millisecond) // 持有时间超过1ms，其它waiter的等待会超过阈值
+				hot.Unlock()
+			}
+		}()
+	}
+	cold.Lock()
+	cold.Unlock()
+	wg.Wait()
+
+	top := p.Top(1)
+	if len(top) != 1 || top[0].Name != "hot" {
+		t.Fatalf("expect hot lock on top, got %+v", top)
+	}
+	s := top[0]
+	if s.Wait.Count != 50 || s.Hold.Count != 50 {
+		t.Fatalf("expect 50 acquires, got wait=%d hold=%d", s.Wait.Count, s.Hold.Count)
+	}
+	if s.Hold.Mean() < 2*time.Millisecond {
+		t.Fatalf("expect hold time >= 2ms but got %v", s.Hold.Mean())
+	}
+	if s.Starvation == 0 {
+		t.Fatal("expect starvation mode to be recorded")
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteReport(&buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "hot") || !strings.Contains(lines[2], "cold") {
+		t.Fatalf("unexpected report:\n%s", buf.String())
+	}
+}
+
+// 只有包装的是*sync.Mutex时才统计饥饿模式，其它的锁不会误报
+func TestStarvationOnlyForMutex(t *testing.T) {
+	p := NewProfiler()
+	l := p.Locker("rw", &sync.RWMutex{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				l.Lock()
+				time.Sleep(2 * time.Millisecond)
+				l.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if s := p.Stats("rw").Snapshot(); s.Starvation != 0 || s.Wait.Max < time.Millisecond {
+		t.Fatalf("expect long waits without starvation but got %d, max wait %v", s.Starvation, s.Wait.Max)
+	}
+}
+
+func BenchmarkLocker(b *testing.B) {
+	l := NewProfiler().Mutex("bench")
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.Lock()
+			l.Unlock()
+		}
+	})
+}
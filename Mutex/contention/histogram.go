@@ -0,0 +1,102 @@
+package contention
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// 第i个桶记录 [2^i, 2^(i+1)) 纳秒的样本，最后一个桶兜底，2^39ns 大约是9分钟
+const numBuckets = 40
+
+// Histogram 以2的幂为边界的延迟直方图，记录时只用原子操作，不加锁
+type Histogram struct {
+	count   uint64
+	sum     uint64 // 纳秒
+	max     uint64 // 纳秒
+	buckets [numBuckets]uint64
+}
+
+func bucketOf(ns uint64) int {
+	if ns == 0 {
+		return 0
+	}
+	i := bits.Len64(ns) - 1
+	if i >= numBuckets {
+		i = numBuckets - 1
+	}
+	return i
+}
+
+// Observe 记录一个样本
+func (h *Histogram) Observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	ns := uint64(d)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, ns)
+	atomic.AddUint64(&h.buckets[bucketOf(ns)], 1)
+	for {
+		old := atomic.LoadUint64(&h.max)
+		if ns <= old || atomic.CompareAndSwapUint64(&h.max, old, ns) {
+			return
+		}
+	}
+}
+
+// Snapshot 返回直方图当前的副本，各个字段之间不保证严格一致
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Count: atomic.LoadUint64(&h.count),
+		Sum:   time.Duration(atomic.LoadUint64(&h.sum)),
+		Max:   time.Duration(atomic.LoadUint64(&h.max)),
+	}
+	for i := range h.buckets {
+		s.Buckets[i] = atomic.LoadUint64(&h.buckets[i])
+	}
+	return s
+}
+
+// HistogramSnapshot 直方图的只读副本
+type HistogramSnapshot struct {
+	Count   uint64
+	Sum     time.Duration
+	Max     time.Duration
+	Buckets [numBuckets]uint64
+}
+
+// Mean 平均值
+func (s HistogramSnapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile 返回分位数q(0~1)所在桶的上界，不会超过记录到的最大值
+func (s HistogramSnapshot) Quantile(q float64) time.Duration {
+	var total uint64
+	for _, n := range s.Buckets {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(total))
+	if rank >= total {
+		rank = total - 1
+	}
+	var seen uint64
+	for i, n := range s.Buckets {
+		seen += n
+		if seen > rank {
+			upper := time.Duration(uint64(1)<<uint(i+1) - 1)
+			if upper > s.Max {
+				upper = s.Max
+			}
+			return upper
+		}
+	}
+	return s.Max
+}
@@ -0,0 +1,156 @@
+// Package ctxmutex 提供可以被context取消的互斥锁。
+//
+// 没有竞争的时候加锁、解锁都只是一次CAS，和sync.Mutex一样；有竞争的时候，
+// waiter进入一个先入先出的队列，释放锁时直接把锁交给队头的waiter。
+// waiter在等待期间如果context被取消或者超时，会把自己从队列中移除。
+package ctxmutex
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	mutexLocked  = 1 << iota // 锁被持有
+	mutexWaiters             // 队列中有waiter，解锁需要走慢路径
+)
+
+// Mutex 支持LockContext、LockTimeout和TryLock的互斥锁，零值可用。
+// 它实现了sync.Locker，Lock和TryLock可以和LockContext混合使用。
+type Mutex struct {
+	state   int32
+	mu      sync.Mutex // 保护waiters
+	waiters list.List  // *waiter
+}
+
+type waiter struct {
+	ch      chan struct{} // 锁交给这个waiter时关闭
+	granted bool          // 在mu的保护下读写
+}
+
+// Lock 请求锁，直到获取到
+func (m *Mutex) Lock() {
+	// Fast path: 没有竞争，直接获取到锁
+	if atomic.CompareAndSwapInt32(&m.state, 0, mutexLocked) {
+		return
+	}
+	m.lockSlow(nil)
+}
+
+// TryLock 尝试获取锁，不会阻塞
+func (m *Mutex) TryLock() bool {
+	return atomic.CompareAndSwapInt32(&m.state, 0, mutexLocked)
+}
+
+// LockContext 请求锁，在获取到锁之前ctx被取消的话返回ctx.Err()，此时没有持有锁
+func (m *Mutex) LockContext(ctx context.Context) error {
+	if atomic.CompareAndSwapInt32(&m.state, 0, mutexLocked) {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.lockSlow(ctx)
+}
+
+// LockTimeout 在timeout时间内请求锁，返回是否获取到了锁
+func (m *Mutex) LockTimeout(timeout time.Duration) bool {
+	if atomic.CompareAndSwapInt32(&m.state, 0, mutexLocked) {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.lockSlow(ctx) == nil
+}
+
+// lockSlow 排队等待，ctx为nil的时候不会被取消
+func (m *Mutex) lockSlow(ctx context.Context) error {
+	m.mu.Lock()
+	for {
+		old := atomic.LoadInt32(&m.state)
+		if old&mutexLocked == 0 { // 锁在这期间被释放了，再抢一次
+			if atomic.CompareAndSwapInt32(&m.state, old, old|mutexLocked) {
+				m.mu.Unlock()
+				return nil
+			}
+			continue
+		}
+		// 设置waiter标记，让Unlock走慢路径把锁交给我们
+		if atomic.CompareAndSwapInt32(&m.state, old, old|mutexWaiters) {
+			break
+		}
+	}
+	w := &waiter{ch: make(chan struct{})}
+	e := m.waiters.PushBack(w)
+	m.mu.Unlock()
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case <-w.ch:
+		return nil
+	case <-done:
+	}
+
+	m.mu.Lock()
+	if w.granted { // 取消的同时锁已经交给了我们，只能再交出去
+		m.mu.Unlock()
+		m.Unlock()
+		return ctx.Err()
+	}
+	m.waiters.Remove(e)
+	if m.waiters.Len() == 0 {
+		// 持有mu的时候state只能是 mutexLocked|mutexWaiters，清除waiter标记
+		atomic.StoreInt32(&m.state, mutexLocked)
+	}
+	m.mu.Unlock()
+	return ctx.Err()
+}
+
+// Unlock 释放锁，有waiter的话直接交给队头的waiter
+func (m *Mutex) Unlock() {
+	// Fast path: 没有waiter
+	if atomic.CompareAndSwapInt32(&m.state, mutexLocked, 0) {
+		return
+	}
+	m.unlockSlow()
+}
+
+func (m *Mutex) unlockSlow() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := atomic.LoadInt32(&m.state)
+	if state&mutexLocked == 0 {
+		panic("sync: unlock of unlocked mutex")
+	}
+	if state == mutexLocked { // 最后一个waiter刚好取消了
+		atomic.StoreInt32(&m.state, 0)
+		return
+	}
+	e := m.waiters.Front()
+	w := m.waiters.Remove(e).(*waiter)
+	if m.waiters.Len() == 0 {
+		atomic.StoreInt32(&m.state, mutexLocked)
+	}
+	// 锁的标记保持不变，直接移交所有权
+	w.granted = true
+	close(w.ch)
+}
+
+// IsLocked 锁是否被持有
+func (m *Mutex) IsLocked() bool {
+	return atomic.LoadInt32(&m.state)&mutexLocked != 0
+}
+
+// Waiters 正在排队的waiter数量
+func (m *Mutex) Waiters() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.waiters.Len()
+}
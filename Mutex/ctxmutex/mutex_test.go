@@ -0,0 +1,137 @@
+package ctxmutex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ sync.Locker = (*Mutex)(nil)
+
+func TestCounter(t *testing.T) {
+	var mu Mutex
+	var count int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				switch j % 3 { // 混合使用三种加锁方式
+				case 0:
+					mu.Lock()
+				case 1:
+					if err := mu.LockContext(context.Background()); err != nil {
+						t.Error(err)
+						return
+					}
+				default:
+					for !mu.TryLock() {
+					}
+				}
+				count++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if count != 100000 {
+		t.Fatalf("expect 100000 but got %d", count)
+	}
+}
+
+func TestLockContextCancel(t *testing.T) {
+	var mu Mutex
+	mu.Lock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := mu.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded but got %v", err)
+	}
+	if n := mu.Waiters(); n != 0 {
+		t.Fatalf("expect waiter removed from queue, got %d waiters", n)
+	}
+	if mu.LockTimeout(10 * time.Millisecond) {
+		t.Fatal("expect LockTimeout to fail")
+	}
+
+	// 取消的waiter不在队列里了，释放之后锁是空闲的
+	mu.Unlock()
+	if !mu.TryLock() {
+		t.Fatal("expect the lock to be free")
+	}
+	mu.Unlock()
+
+	cancel()
+	if err := mu.LockContext(ctx); err != nil { // 锁空闲时直接获取
+		t.Fatalf("expect fast path to succeed but got %v", err)
+	}
+	mu.Unlock()
+}
+
+// 队列中间的waiter取消之后，其余的waiter依次获取到锁
+func TestCancelInQueue(t *testing.T) {
+	var mu Mutex
+	mu.Lock()
+
+	got := make(chan int, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			c := context.Background()
+			if i == 1 {
+				c = ctx
+			}
+			if err := mu.LockContext(c); err != nil {
+				errc <- err
+				return
+			}
+			got <- i
+			mu.Unlock()
+		}(i)
+		for mu.Waiters() != i+1 { // 保证按顺序入队
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("expect Canceled but got %v", err)
+	}
+	mu.Unlock()
+
+	if a, b := <-got, <-got; a != 0 || b != 2 {
+		t.Fatalf("expect waiters 0 and 2 in order, got %d %d", a, b)
+	}
+}
+
+func BenchmarkMutex(b *testing.B) {
+	var mu Mutex
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			mu.Unlock()
+		}
+	})
+}
+
+func BenchmarkSyncMutex(b *testing.B) {
+	var mu sync.Mutex
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			mu.Unlock()
+		}
+	})
+}
+
+func BenchmarkUncontended(b *testing.B) {
+	var mu Mutex
+	for i := 0; i < b.N; i++ {
+		mu.Lock()
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,389 @@
+// Package deadlock 提供可以检测加锁顺序的Mutex和RWMutex。
+//
+// 每次加锁时按goroutine id记录它已经持有了哪些锁，据此构建一个全局的加锁顺序图：
+// 持有A的时候再去请求B，就记录一条 A->B 的边。一旦新加入的边让图中出现了环，
+// 就说明存在以相反顺序请求锁的代码路径，即使这次运行并没有真的死锁，也会把
+// 环上每一次加锁的调用栈报告出来。每个环只报告一次。
+//
+// 同一个goroutine再次请求自己已经持有的锁会立即死锁，同样会报告，
+// 只有重复持有读锁是允许的。
+//
+// 图中的节点是每把锁第一次加锁时分配的编号，不引用锁本身，用过的锁可以被回收；
+// 但是检测器不知道锁什么时候被回收了，它的边会一直留在图中。为了不让每个请求、
+// 每个对象一把锁的程序无限增长，图中最多保存65536条边，超过之后按加入的先后淘汰
+// 最早的边，已经报告过的环也只记住最近的4096个。被淘汰的边不再参与检测，
+// 很久以前出现过一次的加锁顺序可能漏报，淘汰之后再次出现的环会再报告一次。
+//
+// 这些锁是可选的调试工具，用法和sync.Mutex、sync.RWMutex一样，零值可用。
+package deadlock
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/petermattis/goid"
+)
+
+// 记录调用栈的最大深度
+const maxStackDepth = 32
+
+const (
+	maxEdges    = 1 << 16 // 图中最多保存的边数
+	maxReported = 1 << 12 // 最多记住的已报告的环
+)
+
+// Edge 加锁顺序图中的一条边：某个goroutine持有From的时候请求了To
+type Edge struct {
+	From, To    string    // 锁的标识
+	GoroutineID int64     // 发生这次加锁的goroutine
+	FromStack   []uintptr // 获取From时的调用栈
+	ToStack     []uintptr // 请求To时的调用栈
+
+	from, to uint64
+}
+
+// Report 描述一个潜在的死锁，也就是加锁顺序图中的一个环。
+// Cycle[0] 是触发检测的那次加锁，后面依次是之前记录下来的、构成环的边。
+type Report struct {
+	Cycle []*Edge
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	if len(r.Cycle) == 1 && r.Cycle[0].from == r.Cycle[0].to {
+		b.WriteString("DEADLOCK: lock already held by the same goroutine\n")
+	} else {
+		b.WriteString("POTENTIAL DEADLOCK: inconsistent lock ordering\n")
+	}
+	for i, e := range r.Cycle {
+		fmt.Fprintf(&b, "\n#%d goroutine %d locked %s then %s\n", i, e.GoroutineID, e.From, e.To)
+		fmt.Fprintf(&b, "  %s acquired at:\n", e.From)
+		writeStack(&b, e.FromStack)
+		fmt.Fprintf(&b, "  %s requested at:\n", e.To)
+		writeStack(&b, e.ToStack)
+	}
+	return b.String()
+}
+
+func writeStack(b *strings.Builder, pcs []uintptr) {
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(b, "    %s\n      %s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			return
+		}
+	}
+}
+
+// 默认把报告输出到标准错误
+func defaultReport(r *Report) {
+	fmt.Fprintln(os.Stderr, r)
+}
+
+// lockID 锁的编号，第一次加锁时分配，零值可用
+type lockID struct {
+	id uint64
+}
+
+var lastLockID uint64
+
+func (l *lockID) get() uint64 {
+	if id := atomic.LoadUint64(&l.id); id != 0 {
+		return id
+	}
+	atomic.CompareAndSwapUint64(&l.id, 0, atomic.AddUint64(&lastLockID, 1))
+	return atomic.LoadUint64(&l.id)
+}
+
+// 已经持有的一把锁。释放时就删除了，所以可以引用锁本身，用来生成报告中的标识。
+type heldLock struct {
+	lock   interface{}
+	id     uint64
+	shared bool // 读锁
+	stack  []uintptr
+}
+
+// detector 维护加锁顺序图和每个goroutine当前持有的锁
+type detector struct {
+	mu       sync.Mutex
+	graph    map[uint64]map[uint64]*Edge // from -> to -> edge，key是锁的编号
+	edges    []*Edge                     // 图中的边，按加入的先后，用于淘汰
+	held     map[int64][]heldLock        // goroutine id -> 持有的锁，按加锁顺序
+	reported map[string]bool             // 已经报告过的环
+	cycles   []string                    // reported中的key，按报告的先后
+	report   func(*Report)
+
+	maxEdges, maxReported int
+}
+
+func newDetector() *detector {
+	d := &detector{report: defaultReport, maxEdges: maxEdges, maxReported: maxReported}
+	d.reset()
+	return d
+}
+
+func (d *detector) reset() {
+	d.graph = make(map[uint64]map[uint64]*Edge)
+	d.edges = nil
+	d.held = make(map[int64][]heldLock)
+	d.reported = make(map[string]bool)
+	d.cycles = nil
+}
+
+var defaultDetector = newDetector()
+
+// SetReportFunc 设置发现潜在死锁时的回调，传入nil恢复为输出到标准错误。
+// 回调在检测器的锁之外执行，但是在请求锁之前，所以即使真的发生了死锁也能得到报告。
+func SetReportFunc(fn func(*Report)) {
+	if fn == nil {
+		fn = defaultReport
+	}
+	defaultDetector.mu.Lock()
+	defaultDetector.report = fn
+	defaultDetector.mu.Unlock()
+}
+
+// Reset 清空记录下来的加锁顺序图，主要用于测试
+func Reset() {
+	d := defaultDetector
+	d.mu.Lock()
+	d.reset()
+	d.mu.Unlock()
+}
+
+func callers() []uintptr {
+	var pcs [maxStackDepth]uintptr
+	n := runtime.Callers(3, pcs[:]) // 跳过runtime.Callers、callers和Lock本身
+	return pcs[:n]
+}
+
+func label(l interface{}) string {
+	return fmt.Sprintf("%T(%p)", l, l)
+}
+
+// before 在请求锁之前调用：为已持有的每把锁加一条到l的边，并检查是否成环。
+// shared表示请求的是读锁。
+func (d *detector) before(l interface{}, id uint64, shared bool, gid int64, stack []uintptr) {
+	var reports []*Report
+
+	d.mu.Lock()
+	for _, h := range d.held[gid] {
+		if h.id == id {
+			if shared && h.shared { // 重复持有读锁，不记录自环
+				continue
+			}
+			// 自己等自己，不需要别的goroutine参与就会死锁
+			e := &Edge{
+				From: label(h.lock), To: label(l), GoroutineID: gid,
+				FromStack: h.stack, ToStack: stack,
+				from: id, to: id,
+			}
+			if cycle := []*Edge{e}; d.markReported(cycle) {
+				reports = append(reports, &Report{Cycle: cycle})
+			}
+			continue
+		}
+		edges := d.graph[h.id]
+		if edges == nil {
+			edges = make(map[uint64]*Edge)
+			d.graph[h.id] = edges
+		}
+		if _, ok := edges[id]; ok { // 这个顺序已经记录过了
+			continue
+		}
+		e := &Edge{
+			From: label(h.lock), To: label(l), GoroutineID: gid,
+			FromStack: h.stack, ToStack: stack,
+			from: h.id, to: id,
+		}
+		edges[id] = e
+		d.addEdge(e)
+
+		// 新边是 h->l，如果之前已经有 l->...->h 的路径，就构成了环
+		path := d.path(id, h.id, make(map[uint64]bool))
+		if path == nil {
+			continue
+		}
+		if cycle := append([]*Edge{e}, path...); d.markReported(cycle) {
+			reports = append(reports, &Report{Cycle: cycle})
+		}
+	}
+	report := d.report
+	d.mu.Unlock()
+
+	for _, r := range reports {
+		report(r)
+	}
+}
+
+// path 深度优先查找 from 到 to 的一条路径
+func (d *detector) path(from, to uint64, visited map[uint64]bool) []*Edge {
+	visited[from] = true
+	for next, e := range d.graph[from] {
+		if next == to {
+			return []*Edge{e}
+		}
+		if visited[next] {
+			continue
+		}
+		if p := d.path(next, to, visited); p != nil {
+			return append([]*Edge{e}, p...)
+		}
+	}
+	return nil
+}
+
+// addEdge 记录新加入图中的边，超过上限时淘汰最早的边
+func (d *detector) addEdge(e *Edge) {
+	d.edges = append(d.edges, e)
+	for len(d.edges) > d.maxEdges {
+		old := d.edges[0]
+		d.edges[0] = nil
+		d.edges = d.edges[1:]
+		if edges := d.graph[old.from]; edges[old.to] == old {
+			delete(edges, old.to)
+			if len(edges) == 0 {
+				delete(d.graph, old.from)
+			}
+		}
+	}
+}
+
+// markReported 记住报告过的环，已经报告过时返回false。超过上限时忘掉最早的环。
+func (d *detector) markReported(cycle []*Edge) bool {
+	key := cycleKey(cycle)
+	if d.reported[key] {
+		return false
+	}
+	d.reported[key] = true
+	d.cycles = append(d.cycles, key)
+	for len(d.cycles) > d.maxReported {
+		delete(d.reported, d.cycles[0])
+		d.cycles[0] = ""
+		d.cycles = d.cycles[1:]
+	}
+	return true
+}
+
+// 同一个环不论从哪条边开始检测到，都得到同样的key。
+// 用锁的编号而不是标识，地址被新的锁复用时不会当成同一个环。
+func cycleKey(cycle []*Edge) string {
+	keys := make([]string, 0, len(cycle))
+	for _, e := range cycle {
+		keys = append(keys, strconv.FormatUint(e.from, 10)+"->"+strconv.FormatUint(e.to, 10))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// acquired 在成功获取锁之后调用，记录下这个goroutine持有了l
+func (d *detector) acquired(l interface{}, id uint64, shared bool, gid int64, stack []uintptr) {
+	d.mu.Lock()
+	d.held[gid] = append(d.held[gid], heldLock{lock: l, id: id, shared: shared, stack: stack})
+	d.mu.Unlock()
+}
+
+// released 在释放锁之前调用。Go允许在别的goroutine中释放锁，所以当前goroutine
+// 没有持有l的时候，再到其它goroutine持有的锁里面去找。
+func (d *detector) released(id uint64, gid int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.remove(gid, id) {
+		return
+	}
+	for g := range d.held {
+		if d.remove(g, id) {
+			return
+		}
+	}
+}
+
+// 从后往前删除，重复持有的读锁最先释放的是最后一次加的
+func (d *detector) remove(gid int64, id uint64) bool {
+	locks := d.held[gid]
+	for i := len(locks) - 1; i >= 0; i-- {
+		if locks[i].id != id {
+			continue
+		}
+		locks = append(locks[:i], locks[i+1:]...)
+		if len(locks) == 0 {
+			delete(d.held, gid)
+		} else {
+			d.held[gid] = locks
+		}
+		return true
+	}
+	return false
+}
+
+// Mutex 会检测加锁顺序的互斥锁
+type Mutex struct {
+	mu sync.Mutex
+	id lockID
+}
+
+// Lock 请求锁
+func (m *Mutex) Lock() {
+	gid, stack, id := goid.Get(), callers(), m.id.get()
+	defaultDetector.before(m, id, false, gid, stack)
+	m.mu.Lock()
+	defaultDetector.acquired(m, id, false, gid, stack)
+}
+
+// Unlock 释放锁
+func (m *Mutex) Unlock() {
+	defaultDetector.released(m.id.get(), goid.Get())
+	m.mu.Unlock()
+}
+
+// RWMutex 会检测加锁顺序的读写锁，读锁和写锁都参与顺序检测：
+// 持有读锁的goroutine反向请求锁时，一旦有writer在等待同样会死锁。
+type RWMutex struct {
+	rw sync.RWMutex
+	id lockID
+}
+
+// Lock 请求写锁
+func (m *RWMutex) Lock() {
+	gid, stack, id := goid.Get(), callers(), m.id.get()
+	defaultDetector.before(m, id, false, gid, stack)
+	m.rw.Lock()
+	defaultDetector.acquired(m, id, false, gid, stack)
+}
+
+// Unlock 释放写锁
+func (m *RWMutex) Unlock() {
+	defaultDetector.released(m.id.get(), goid.Get())
+	m.rw.Unlock()
+}
+
+// RLock 请求读锁
+func (m *RWMutex) RLock() {
+	gid, stack, id := goid.Get(), callers(), m.id.get()
+	defaultDetector.before(m, id, true, gid, stack)
+	m.rw.RLock()
+	defaultDetector.acquired(m, id, true, gid, stack)
+}
+
+// RUnlock 释放读锁
+func (m *RWMutex) RUnlock() {
+	defaultDetector.released(m.id.get(), goid.Get())
+	m.rw.RUnlock()
+}
+
+// RLocker 返回一个用读锁实现的Locker
+func (m *RWMutex) RLocker() sync.Locker {
+	return (*rlocker)(m)
+}
+
+type rlocker RWMutex
+
+func (r *rlocker) Lock()   { (*RWMutex)(r).RLock() }
+func (r *rlocker) Unlock() { (*RWMutex)(r).RUnlock() }
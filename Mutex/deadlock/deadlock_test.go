@@ -0,0 +1,290 @@
+package deadlock
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 收集检测到的报告
+func collect(t *testing.T) func() []*Report {
+	var mu sync.Mutex
+	var reports []*Report
+	Reset()
+	SetReportFunc(func(r *Report) {
+		mu.Lock()
+		reports = append(reports, r)
+		mu.Unlock()
+	})
+	t.Cleanup(func() { SetReportFunc(nil) })
+	return func() []*Report {
+		mu.Lock()
+		defer mu.Unlock()
+		return reports
+	}
+}
+
+// 顺序执行、不会真的死锁，也要报告出相反的加锁顺序
+func TestOppositeOrder(t *testing.T) {
+	reports := collect(t)
+	var a, b Mutex
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() { // 一个goroutine先A后B
+		defer wg.Done()
+		a.Lock()
+		b.Lock()
+		b.Unlock()
+		a.Unlock()
+	}()
+	wg.Wait()
+
+	if n := len(reports()); n != 0 {
+		t.Fatalf("expect no report but got %d", n)
+	}
+
+	wg.Add(1)
+	go func() { // 另一个goroutine先B后A
+		defer wg.Done()
+		b.Lock()
+		a.Lock()
+		a.Unlock()
+		b.Unlock()
+	}()
+	wg.Wait()
+
+	rs := reports()
+	if len(rs) != 1 {
+		t.Fatalf("expect 1 report but got %d", len(rs))
+	}
+	if n := len(rs[0].Cycle); n != 2 {
+		t.Fatalf("expect a cycle of 2 edges but got %d", n)
+	}
+	s := rs[0].String()
+	if !strings.Contains(s, "TestOppositeOrder") {
+		t.Fatalf("expect stacks in report, got:\n%s", s)
+	}
+
+	// 同一个环只报告一次
+	b.Lock()
+	a.Lock()
+	a.Unlock()
+	b.Unlock()
+	if n := len(reports()); n != 1 {
+		t.Fatalf("expect 1 report but got %d", n)
+	}
+}
+
+func TestLongCycle(t *testing.T) {
+	reports := collect(t)
+	var a, b Mutex
+	var c RWMutex
+
+	a.Lock()
+	b.Lock()
+	b.Unlock()
+	a.Unlock()
+
+	b.Lock()
+	c.RLock()
+	c.RUnlock()
+	b.Unlock()
+
+	c.Lock()
+	a.Lock()
+	a.Unlock()
+	c.Unlock()
+
+	rs := reports()
+	if len(rs) != 1 {
+		t.Fatalf("expect 1 report but got %d", len(rs))
+	}
+	if n := len(rs[0].Cycle); n != 3 {
+		t.Fatalf("expect a cycle of 3 edges but got %d", n)
+	}
+}
+
+func TestConsistentOrder(t *testing.T) {
+	reports := collect(t)
+	var a Mutex
+	var b RWMutex
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				a.Lock()
+				b.RLock()
+				b.RLock() // 重复持有读锁不算环
+				b.RUnlock()
+				b.RUnlock()
+				a.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(reports()); n != 0 {
+		t.Fatalf("expect no report but got %d", n)
+	}
+}
+
+// 在另一个goroutine中释放锁，不能留下错误的持有记录
+func TestUnlockInOtherGoroutine(t *testing.T) {
+	reports := collect(t)
+	var a, b Mutex
+
+	a.Lock()
+	done := make(chan struct{})
+	go func() {
+		a.Unlock()
+		close(done)
+	}()
+	<-done
+
+	b.Lock()
+	a.Lock()
+	a.Unlock()
+	b.Unlock()
+
+	if n := len(reports()); n != 0 {
+		t.Fatalf("expect no report but got %d", n)
+	}
+}
+
+// 同一个goroutine重复请求已经持有的锁。回调里panic，让Lock在真正阻塞之前返回。
+func TestRelock(t *testing.T) {
+	Reset()
+	SetReportFunc(func(r *Report) { panic(r) })
+	t.Cleanup(func() { SetReportFunc(nil) })
+
+	relock := func(first, second func()) (r *Report) {
+		first()
+		defer func() { r, _ = recover().(*Report) }()
+		second()
+		return nil
+	}
+
+	var a Mutex
+	r := relock(a.Lock, a.Lock)
+	a.Unlock()
+	if r == nil || len(r.Cycle) != 1 || !strings.HasPrefix(r.String(), "DEADLOCK") {
+		t.Fatalf("expect a relock report but got %v", r)
+	}
+
+	var b RWMutex
+	for _, c := range []struct {
+		name          string
+		first, second func()
+		release       func()
+	}{
+		{"RLock then Lock", b.RLock, b.Lock, b.RUnlock},
+		{"Lock then RLock", b.Lock, b.RLock, b.Unlock},
+		{"Lock then Lock", b.Lock, b.Lock, b.Unlock},
+	} {
+		Reset()
+		r := relock(c.first, c.second)
+		c.release()
+		if r == nil {
+			t.Fatalf("%s: expect a report", c.name)
+		}
+	}
+
+	// 重复的读锁不报告
+	Reset()
+	if r := relock(b.RLock, b.RLock); r != nil {
+		t.Fatalf("expect no report for recursive read lock but got:\n%s", r)
+	}
+	b.RUnlock()
+	b.RUnlock()
+}
+
+// 图中只保存编号，不引用锁本身
+func TestGraphKeyedByID(t *testing.T) {
+	collect(t)
+	var a, b Mutex
+	a.Lock()
+	b.Lock()
+	b.Unlock()
+	a.Unlock()
+	d := defaultDetector
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.graph[a.id.get()][b.id.get()]; !ok {
+		t.Fatalf("expect an edge from %d to %d", a.id.get(), b.id.get())
+	}
+	if len(d.held) != 0 {
+		t.Fatalf("expect no held locks but got %v", d.held)
+	}
+}
+
+// 每次都是新的锁，每个环都要报告，不会因为标识相同被当成已经报告过的
+func TestNewLocksReported(t *testing.T) {
+	reports := collect(t)
+	for i := 0; i < 3; i++ {
+		a, b := new(Mutex), new(Mutex)
+		a.Lock()
+		b.Lock()
+		b.Unlock()
+		a.Unlock()
+		b.Lock()
+		a.Lock()
+		a.Unlock()
+		b.Unlock()
+	}
+	if n := len(reports()); n != 3 {
+		t.Fatalf("expect 3 reports but got %d", n)
+	}
+}
+
+// 超过上限时淘汰最早的边，图不会无限增长
+func TestGraphLimit(t *testing.T) {
+	reports := collect(t)
+	d := defaultDetector
+	d.mu.Lock()
+	d.maxEdges, d.maxReported = 4, 2
+	d.mu.Unlock()
+	t.Cleanup(func() {
+		d.mu.Lock()
+		d.maxEdges, d.maxReported = maxEdges, maxReported
+		d.mu.Unlock()
+	})
+
+	var first [2]Mutex
+	first[0].Lock()
+	first[1].Lock()
+	first[1].Unlock()
+	first[0].Unlock()
+	for i := 0; i < 10; i++ {
+		a, b := new(Mutex), new(Mutex)
+		a.Lock()
+		b.Lock()
+		b.Unlock()
+		a.Unlock()
+		b.Lock()
+		a.Lock()
+		a.Unlock()
+		b.Unlock()
+	}
+
+	d.mu.Lock()
+	n := 0
+	for _, edges := range d.graph {
+		n += len(edges)
+	}
+	_, kept := d.graph[first[0].id.get()]
+	edges, cycles := len(d.edges), len(d.reported)
+	d.mu.Unlock()
+	if n != 4 || edges != 4 || cycles != 2 {
+		t.Fatalf("expect 4 edges and 2 cycles but got %d, %d, %d", n, edges, cycles)
+	}
+	if kept {
+		t.Fatal("expect the oldest edge to be evicted")
+	}
+	if n := len(reports()); n != 10 {
+		t.Fatalf("expect 10 reports but got %d", n)
+	}
+}
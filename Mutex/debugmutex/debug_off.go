@@ -0,0 +1,7 @@
+//go:build !mutexdebug
+// +build !mutexdebug
+
+package debugmutex
+
+// 默认不检查持有者，零值的Mutex和sync.Mutex的开销基本一样
+const defaultChecked = false
@@ -0,0 +1,7 @@
+//go:build mutexdebug
+// +build mutexdebug
+
+package debugmutex
+
+// 使用 -tags mutexdebug 编译时，默认检查持有者
+const defaultChecked = true
@@ -0,0 +1,215 @@
+// Package debugmutex 提供检查持有者的Mutex。
+//
+// sync.Mutex允许在没有加锁的goroutine里调用Unlock，这类错误很难排查。
+// 开启检查后，Mutex会记录持有者的goroutine id和加锁时的调用栈，非持有者调用Unlock
+// 时panic并输出两边的调用栈；Holders可以列出所有被持有的锁，方便在服务卡住的时候
+// 找出是谁一直占着锁。
+//
+// 检查可以用 -tags mutexdebug 对所有零值的Mutex开启，也可以用New(WithChecked(true))
+// 对单个Mutex开启。
+package debugmutex
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+const maxStackDepth = 32
+
+// Mutex 检查持有者的互斥锁，零值可用，是否检查由编译标签决定
+type Mutex struct {
+	mu    sync.Mutex
+	name  string
+	check int8 // 0: 使用defaultChecked，1: 检查，-1: 不检查
+
+	meta   sync.Mutex // 保护下面的持有者信息
+	holder int64      // 持有者的goroutine id，0表示没有被持有
+	stack  []uintptr  // 持有者加锁时的调用栈
+	since  time.Time  // 加锁的时间
+}
+
+// Option Mutex的配置项
+type Option func(*Mutex)
+
+// WithName 设置锁的名字，用在panic信息和Holders的报告里
+func WithName(name string) Option {
+	return func(m *Mutex) {
+		m.name = name
+	}
+}
+
+// WithChecked 开启或关闭持有者检查，不受编译标签的影响
+func WithChecked(checked bool) Option {
+	return func(m *Mutex) {
+		if checked {
+			m.check = 1
+		} else {
+			m.check = -1
+		}
+	}
+}
+
+// New 创建一个Mutex
+func New(opts ...Option) *Mutex {
+	m := &Mutex{}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *Mutex) checked() bool {
+	if m.check == 0 {
+		return defaultChecked
+	}
+	return m.check > 0
+}
+
+func (m *Mutex) label() string {
+	if m.name != "" {
+		return m.name
+	}
+	return fmt.Sprintf("%p", m)
+}
+
+func callers() []uintptr {
+	var pcs [maxStackDepth]uintptr
+	n := runtime.Callers(3, pcs[:]) // 跳过runtime.Callers、callers和Lock/Unlock本身
+	return pcs[:n]
+}
+
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+// Lock 请求锁，开启检查时记录持有者
+func (m *Mutex) Lock() {
+	m.mu.Lock()
+	if !m.checked() {
+		return
+	}
+	m.meta.Lock()
+	m.holder = goid.Get()
+	m.stack = callers()
+	m.since = time.Now()
+	m.meta.Unlock()
+	register(m)
+}
+
+// Unlock 释放锁，开启检查时非持有者调用会panic
+func (m *Mutex) Unlock() {
+	if !m.checked() {
+		m.mu.Unlock()
+		return
+	}
+	gid := goid.Get()
+	m.meta.Lock()
+	if m.holder == 0 {
+		m.meta.Unlock()
+		panic(fmt.Sprintf("debugmutex: unlock of unlocked mutex %s by goroutine %d\n\nunlock called at:\n%s",
+			m.label(), gid, formatStack(callers())))
+	}
+	if m.holder != gid {
+		holder, stack := m.holder, m.stack
+		m.meta.Unlock()
+		panic(fmt.Sprintf("debugmutex: mutex %s held by goroutine %d unlocked by goroutine %d\n\nlocked at:\n%s\nunlock called at:\n%s",
+			m.label(), holder, gid, formatStack(stack), formatStack(callers())))
+	}
+	m.holder = 0
+	m.stack = nil
+	m.meta.Unlock()
+	unregister(m)
+	m.mu.Unlock()
+}
+
+// Holder 当前持有锁的goroutine的信息
+type Holder struct {
+	Name        string
+	GoroutineID int64
+	Since       time.Time // 加锁的时间
+	Stack       string    // 加锁时的调用栈
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("mutex %s held by goroutine %d for %v, locked at:\n%s",
+		h.Name, h.GoroutineID, time.Since(h.Since).Round(time.Millisecond), h.Stack)
+}
+
+// Holder 返回当前的持有者，没有被持有或者没有开启检查时ok为false
+func (m *Mutex) Holder() (h Holder, ok bool) {
+	m.meta.Lock()
+	defer m.meta.Unlock()
+	if m.holder == 0 {
+		return Holder{}, false
+	}
+	return Holder{
+		Name:        m.label(),
+		GoroutineID: m.holder,
+		Since:       m.since,
+		Stack:       formatStack(m.stack),
+	}, true
+}
+
+// 所有被持有的、开启了检查的Mutex
+var (
+	heldMu sync.Mutex
+	held   = make(map[*Mutex]struct{})
+)
+
+func register(m *Mutex) {
+	heldMu.Lock()
+	held[m] = struct{}{}
+	heldMu.Unlock()
+}
+
+func unregister(m *Mutex) {
+	heldMu.Lock()
+	delete(held, m)
+	heldMu.Unlock()
+}
+
+// Holders 返回所有被持有的Mutex的持有者，持有时间最长的在前面
+func Holders() []Holder {
+	heldMu.Lock()
+	locks := make([]*Mutex, 0, len(held))
+	for m := range held {
+		locks = append(locks, m)
+	}
+	heldMu.Unlock()
+
+	holders := make([]Holder, 0, len(locks))
+	for _, m := range locks {
+		if h, ok := m.Holder(); ok {
+			holders = append(holders, h)
+		}
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		return holders[i].Since.Before(holders[j].Since)
+	})
+	return holders
+}
+
+// WriteHolders 把Holders的结果输出到w
+func WriteHolders(w io.Writer) error {
+	for _, h := range Holders() {
+		if _, err := fmt.Fprintln(w, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
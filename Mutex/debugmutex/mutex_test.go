@@ -0,0 +1,97 @@
+package debugmutex
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var _ sync.Locker = (*Mutex)(nil)
+
+func TestUnlockByOtherGoroutine(t *testing.T) {
+	m := New(WithName("orders"), WithChecked(true))
+	m.Lock()
+	defer m.Unlock()
+
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		m.Unlock()
+	}()
+	msg, _ := (<-panicked).(string)
+	for _, want := range []string{"orders", "locked at:", "unlock called at:", "TestUnlockByOtherGoroutine"} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("expect %q in panic message, got:\n%s", want, msg)
+		}
+	}
+}
+
+func TestUnlockOfUnlocked(t *testing.T) {
+	m := New(WithChecked(true))
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, "unlock of unlocked mutex") {
+			t.Fatalf("unexpected panic: %q", msg)
+		}
+	}()
+	m.Unlock()
+}
+
+func TestHolders(t *testing.T) {
+	a := New(WithName("a"), WithChecked(true))
+	b := New(WithName("b"), WithChecked(true))
+	off := New(WithName("off"), WithChecked(false))
+
+	a.Lock()
+	b.Lock()
+	off.Lock()
+	b.Unlock()
+
+	h, ok := a.Holder()
+	if !ok || h.Name != "a" || !strings.Contains(h.Stack, "TestHolders") {
+		t.Fatalf("unexpected holder: %+v", h)
+	}
+	if _, ok := b.Holder(); ok {
+		t.Fatal("expect b to be free")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHolders(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s := buf.String()
+	if !strings.Contains(s, "mutex a held by goroutine") || strings.Contains(s, "mutex b") || strings.Contains(s, "mutex off") {
+		t.Fatalf("unexpected report:\n%s", s)
+	}
+
+	a.Unlock()
+	off.Unlock()
+	if n := len(Holders()); n != 0 {
+		t.Fatalf("expect no holders but got %d", n)
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	var m Mutex
+	var count int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Lock()
+				count++
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 10000 {
+		t.Fatalf("expect 10000 but got %d", count)
+	}
+	if _, ok := m.Holder(); ok {
+		t.Fatal("expect no holder")
+	}
+}
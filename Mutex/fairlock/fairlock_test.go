@@ -0,0 +1,142 @@
+package fairlock
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	_ sync.Locker = (*TicketLock)(nil)
+	_ sync.Locker = (*HybridLock)(nil)
+)
+
+func testCounter(t *testing.T, l sync.Locker) {
+	var count int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				l.Lock()
+				count++
+				l.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 100000 {
+		t.Fatalf("expect 100000 but got %d", count)
+	}
+}
+
+func TestCounter(t *testing.T) {
+	t.Run("ticket", func(t *testing.T) { testCounter(t, &TicketLock{}) })
+	t.Run("hybrid", func(t *testing.T) { testCounter(t, &HybridLock{}) })
+	t.Run("hybrid-strict", func(t *testing.T) {
+		testCounter(t, NewHybrid(WithStarvationThreshold(0), WithSpinBudget(0)))
+	})
+}
+
+// 排号锁严格按照请求的顺序获取锁
+func TestTicketFIFO(t *testing.T) {
+	var l TicketLock
+	l.Lock()
+
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.Lock()
+			order = append(order, i)
+			l.Unlock()
+		}(i)
+		for l.Waiters() != i+1 { // 等这个goroutine领到号
+			runtime.Gosched()
+		}
+	}
+	l.Unlock()
+	wg.Wait()
+
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("expect FIFO order but got %v", order)
+		}
+	}
+	if st := l.Stats(); st.Acquires != 11 || st.Handoffs == 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestTicketUnlockOfUnlocked(t *testing.T) {
+	var l TicketLock
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic")
+		}
+	}()
+	l.Unlock()
+}
+
+// 临界区比饥饿阈值长，waiter会进入饥饿模式，锁直接移交
+func TestHybridStarvation(t *testing.T) {
+	l := NewHybrid(WithStarvationThreshold(100 * time.Microsecond))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				l.Lock()
+				time.Sleep(200 * time.Microsecond)
+				l.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	st := l.Stats()
+	if st.Acquires != 80 {
+		t.Fatalf("expect 80 acquires but got %d", st.Acquires)
+	}
+	if st.Starvation == 0 || st.Handoffs == 0 {
+		t.Fatalf("expect starvation mode to be used, got %+v", st)
+	}
+	if l.Starving() || l.Waiters() != 0 {
+		t.Fatal("expect the lock to leave starvation mode when idle")
+	}
+}
+
+func TestHybridUnlockOfUnlocked(t *testing.T) {
+	var l HybridLock
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic")
+		}
+	}()
+	l.Unlock()
+}
+
+func BenchmarkTicketLock(b *testing.B) {
+	var l TicketLock
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.Lock()
+			l.Unlock()
+		}
+	})
+}
+
+func BenchmarkHybridLock(b *testing.B) {
+	var l HybridLock
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.Lock()
+			l.Unlock()
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package fairlock
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	mutexLocked   = 1 << iota // 锁被持有
+	mutexWaiters              // 有waiter在排队，解锁需要走慢路径
+	mutexStarving             // 饥饿模式
+
+	// 默认值和sync.Mutex一样
+	defaultStarvationThreshold = time.Millisecond
+	defaultSpinBudget          = 4
+)
+
+// HybridLock 正常模式下，被唤醒的waiter要和新来的goroutine竞争锁，吞吐量高；
+// waiter等待超过阈值之后进入饥饿模式，释放锁时直接交给队头的waiter，新来的
+// goroutine不再抢锁，直接排到队尾。零值可用，使用和sync.Mutex一样的默认配置。
+type HybridLock struct {
+	acquires   uint64
+	handoffs   uint64
+	starvation uint64
+
+	threshold time.Duration // 进入饥饿模式的等待时间阈值
+	spin      int           // 进入休眠之前自旋的次数
+	state     int32
+
+	mu      sync.Mutex
+	waiters list.List // *hybridWaiter
+}
+
+type hybridWaiter struct {
+	start time.Time
+	ch    chan bool // true表示锁直接交给了这个waiter，false表示唤醒后去竞争
+}
+
+// HybridOption HybridLock的配置项
+type HybridOption func(*HybridLock)
+
+// WithStarvationThreshold 设置进入饥饿模式的等待时间阈值，0表示被唤醒后没抢到锁就进入饥饿模式
+func WithStarvationThreshold(d time.Duration) HybridOption {
+	return func(l *HybridLock) {
+		if d <= 0 {
+			d = -1 // 和零值的默认配置区分开
+		}
+		l.threshold = d
+	}
+}
+
+// WithSpinBudget 设置进入休眠之前自旋的次数，0表示不自旋
+func WithSpinBudget(n int) HybridOption {
+	return func(l *HybridLock) {
+		if n <= 0 {
+			n = -1
+		}
+		l.spin = n
+	}
+}
+
+// NewHybrid 创建一个HybridLock
+func NewHybrid(opts ...HybridOption) *HybridLock {
+	l := &HybridLock{}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func (l *HybridLock) starvationThreshold() time.Duration {
+	switch {
+	case l.threshold == 0:
+		return defaultStarvationThreshold
+	case l.threshold < 0:
+		return 0
+	}
+	return l.threshold
+}
+
+func (l *HybridLock) spinBudget() int {
+	switch {
+	case l.spin == 0:
+		return defaultSpinBudget
+	case l.spin < 0:
+		return 0
+	}
+	return l.spin
+}
+
+// Lock 请求锁
+func (l *HybridLock) Lock() {
+	// Fast path: 没有被持有，也不是饥饿模式
+	if atomic.CompareAndSwapInt32(&l.state, 0, mutexLocked) {
+		atomic.AddUint64(&l.acquires, 1)
+		return
+	}
+	// 自旋等待锁被释放，饥饿模式下不自旋
+	if spinnable() {
+		for i := l.spinBudget(); i > 0; i-- {
+			doSpin()
+			if atomic.CompareAndSwapInt32(&l.state, 0, mutexLocked) {
+				atomic.AddUint64(&l.acquires, 1)
+				return
+			}
+			if atomic.LoadInt32(&l.state)&mutexStarving != 0 {
+				break
+			}
+		}
+	}
+	l.lockSlow()
+	atomic.AddUint64(&l.acquires, 1)
+}
+
+func (l *HybridLock) lockSlow() {
+	w := &hybridWaiter{start: time.Now(), ch: make(chan bool, 1)}
+	threshold := l.starvationThreshold()
+	starving := false
+	requeue := false
+	for {
+		l.mu.Lock()
+		for {
+			old := atomic.LoadInt32(&l.state)
+			// 饥饿模式一定是持有锁的状态，锁空闲时直接抢
+			if old&mutexLocked == 0 {
+				if atomic.CompareAndSwapInt32(&l.state, old, old|mutexLocked) {
+					l.mu.Unlock()
+					return
+				}
+				continue
+			}
+			new := old | mutexWaiters
+			if starving {
+				new |= mutexStarving
+			}
+			if atomic.CompareAndSwapInt32(&l.state, old, new) {
+				if new&mutexStarving != 0 && old&mutexStarving == 0 {
+					atomic.AddUint64(&l.starvation, 1)
+				}
+				break
+			}
+		}
+		// 被唤醒后没有抢到锁的waiter排到队头
+		if requeue {
+			l.waiters.PushFront(w)
+		} else {
+			l.waiters.PushBack(w)
+		}
+		l.mu.Unlock()
+
+		if <-w.ch { // 锁直接交给了我们
+			return
+		}
+		requeue = true
+		starving = starving || time.Since(w.start) > threshold
+	}
+}
+
+// Unlock 释放锁
+func (l *HybridLock) Unlock() {
+	// Fast path: 没有waiter
+	if atomic.CompareAndSwapInt32(&l.state, mutexLocked, 0) {
+		return
+	}
+	l.unlockSlow()
+}
+
+func (l *HybridLock) unlockSlow() {
+	l.mu.Lock()
+	// 持有mu并且锁被持有的时候，只有我们会修改state
+	old := atomic.LoadInt32(&l.state)
+	if old&mutexLocked == 0 {
+		l.mu.Unlock()
+		panic("fairlock: unlock of unlocked HybridLock")
+	}
+	e := l.waiters.Front()
+	if e == nil {
+		atomic.StoreInt32(&l.state, 0)
+		l.mu.Unlock()
+		return
+	}
+	w := l.waiters.Remove(e).(*hybridWaiter)
+	new := old
+	if l.waiters.Len() == 0 {
+		new &^= mutexWaiters
+	}
+
+	if old&mutexStarving == 0 {
+		// 正常模式：释放锁，唤醒队头的waiter去竞争
+		atomic.StoreInt32(&l.state, new&^mutexLocked)
+		l.mu.Unlock()
+		w.ch <- false
+		return
+	}
+
+	// 饥饿模式：锁保持持有的状态，直接移交给队头的waiter。
+	// 最后一个waiter，或者这个waiter等待的时间没有超过阈值，退出饥饿模式。
+	if new&mutexWaiters == 0 || time.Since(w.start) <= l.starvationThreshold() {
+		new &^= mutexStarving
+	}
+	atomic.StoreInt32(&l.state, new)
+	l.mu.Unlock()
+	atomic.AddUint64(&l.handoffs, 1)
+	w.ch <- true
+}
+
+// Waiters 正在排队的waiter数量
+func (l *HybridLock) Waiters() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiters.Len()
+}
+
+// Starving 是否处于饥饿模式
+func (l *HybridLock) Starving() bool {
+	return atomic.LoadInt32(&l.state)&mutexStarving != 0
+}
+
+// Stats 返回统计信息
+func (l *HybridLock) Stats() Stats {
+	return Stats{
+		Acquires:   atomic.LoadUint64(&l.acquires),
+		Handoffs:   atomic.LoadUint64(&l.handoffs),
+		Starvation: atomic.LoadUint64(&l.starvation),
+	}
+}
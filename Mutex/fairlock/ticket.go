@@ -0,0 +1,125 @@
+// Package fairlock 提供公平的锁，给对延迟敏感、更看重公平而不是吞吐量的场景使用：
+//   - TicketLock：排号锁，严格按照请求的先后顺序获取锁；
+//   - HybridLock：和sync.Mutex一样分为正常模式和饥饿模式，但是进入饥饿模式的
+//     等待时间阈值和自旋的次数可以按实例配置。
+//
+// 两者都实现了sync.Locker，并且统计了有多少次是把锁直接移交给waiter的。
+package fairlock
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats 锁的统计信息
+type Stats struct {
+	Acquires   uint64 // 获取锁的次数
+	Handoffs   uint64 // 释放锁时直接移交给waiter的次数
+	Starvation uint64 // 进入饥饿模式的次数，TicketLock总是0
+}
+
+// 和runtime中的 active_spin_cnt 一致，每次自旋空转的循环数
+const spinCnt = 30
+
+var spinSink int32
+
+func doSpin() {
+	for i := 0; i < spinCnt; i++ {
+		atomic.LoadInt32(&spinSink)
+	}
+}
+
+// 单核的时候自旋没有意义，持有锁的goroutine得不到运行
+func spinnable() bool {
+	return runtime.GOMAXPROCS(0) > 1
+}
+
+// TicketLock 排号锁：请求锁时领一个号，按号的顺序依次获取锁，严格先入先出。
+// 轮到之前先自旋一会儿，还没轮到就休眠，释放锁时唤醒下一个号。零值可用。
+type TicketLock struct {
+	acquires uint64
+	handoffs uint64
+
+	next   uint32 // 下一个要发出的号
+	owner  uint32 // 当前持有锁的号
+	parked int32  // 休眠的waiter数量
+
+	mu      sync.Mutex
+	waiters map[uint32]chan struct{} // 号 -> 休眠的waiter
+}
+
+// 轮到之前自旋的次数
+const ticketSpin = 4
+
+// Lock 领号并等待轮到自己
+func (l *TicketLock) Lock() {
+	ticket := atomic.AddUint32(&l.next, 1) - 1
+	atomic.AddUint64(&l.acquires, 1)
+	if atomic.LoadUint32(&l.owner) == ticket {
+		return
+	}
+	if spinnable() {
+		for i := 0; i < ticketSpin; i++ {
+			doSpin()
+			if atomic.LoadUint32(&l.owner) == ticket {
+				return
+			}
+		}
+	}
+
+	l.mu.Lock()
+	// 先增加parked再检查owner，和Unlock中先增加owner再检查parked对应，不会丢失唤醒
+	atomic.AddInt32(&l.parked, 1)
+	if atomic.LoadUint32(&l.owner) == ticket {
+		atomic.AddInt32(&l.parked, -1)
+		l.mu.Unlock()
+		return
+	}
+	if l.waiters == nil {
+		l.waiters = make(map[uint32]chan struct{})
+	}
+	ch := make(chan struct{})
+	l.waiters[ticket] = ch
+	l.mu.Unlock()
+	<-ch
+}
+
+// Unlock 释放锁，轮到下一个号
+func (l *TicketLock) Unlock() {
+	if atomic.LoadUint32(&l.owner) == atomic.LoadUint32(&l.next) {
+		panic("fairlock: unlock of unlocked TicketLock")
+	}
+	next := atomic.AddUint32(&l.owner, 1)
+	if atomic.LoadInt32(&l.parked) == 0 {
+		return
+	}
+	l.mu.Lock()
+	ch, ok := l.waiters[next]
+	if ok {
+		delete(l.waiters, next)
+		atomic.AddInt32(&l.parked, -1)
+	}
+	l.mu.Unlock()
+	if ok {
+		atomic.AddUint64(&l.handoffs, 1)
+		close(ch)
+	}
+}
+
+// Waiters 领了号还没有轮到的数量
+func (l *TicketLock) Waiters() int {
+	n := int(atomic.LoadUint32(&l.next)-atomic.LoadUint32(&l.owner)) - 1
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// Stats 返回统计信息
+func (l *TicketLock) Stats() Stats {
+	return Stats{
+		Acquires: atomic.LoadUint64(&l.acquires),
+		Handoffs: atomic.LoadUint64(&l.handoffs),
+	}
+}
@@ -0,0 +1,369 @@
+// Package filelock 提供跨进程的锁，用flock锁住一个锁文件。
+//
+// 多个进程对同一个数据目录操作的时候，进程内的Mutex、信号量都不起作用，
+// 这时可以让它们都去锁同一个文件。进程退出时操作系统会自动释放flock，
+// 持有锁的进程会把自己的PID记录在锁文件里，用来查看是谁持有了锁，
+// 以及发现之前的持有者没有正常释放就退出了（stale lock）。
+//
+// 锁文件每行一条记录：获取锁时写入"PID"，释放共享锁时追加"-PID"。
+// 写锁的持有者独占文件，获取时清掉之前的记录只留下自己，释放时清空；
+// 共享锁的持有者可能同时有多个，只能追加，记录一直增长到下一次有人获取写锁。
+//
+// FileLock实现了sync.Locker，并且和contention包中进程内的锁一样，可以通过
+// contention.Observer统计等待时间和持有时间。
+package filelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"GoConcurrentProgramming/Mutex/contention"
+	"GoConcurrentProgramming/Mutex/ctxmutex"
+)
+
+// ErrUnsupported 当前平台不支持文件锁
+var ErrUnsupported = errors.New("filelock: not supported on this platform")
+
+// Mode 加锁的模式
+type Mode int
+
+const (
+	Exclusive Mode = iota // 写锁，同一时刻只有一个持有者
+	Shared                // 读锁，可以有多个持有者
+)
+
+func (m Mode) String() string {
+	if m == Shared {
+		return "shared"
+	}
+	return "exclusive"
+}
+
+// 默认LockContext轮询的间隔
+const defaultPollInterval = 10 * time.Millisecond
+
+// FileLock 锁住一个文件的跨进程锁。
+// 同一个FileLock在进程内同一时刻只能被一个goroutine持有，即使是Shared模式；
+// 进程内需要多个共享的持有者时，为每个持有者创建一个FileLock。
+type FileLock struct {
+	path  string
+	mode  Mode
+	poll  time.Duration
+	obs   contention.Observer
+	stale func(pid int)
+
+	mu         ctxmutex.Mutex // 进程内的互斥
+	state      sync.Mutex     // 保护f和acquiredAt，Release可能在没有持有锁的时候被误调用
+	f          *os.File       // 持有锁时打开的锁文件
+	acquiredAt time.Time
+}
+
+// Option FileLock的配置项
+type Option func(*FileLock)
+
+// WithMode 设置加锁的模式，默认是Exclusive
+func WithMode(mode Mode) Option {
+	return func(l *FileLock) {
+		l.mode = mode
+	}
+}
+
+// WithPollInterval 设置LockContext轮询的间隔
+func WithPollInterval(d time.Duration) Option {
+	return func(l *FileLock) {
+		if d > 0 {
+			l.poll = d
+		}
+	}
+}
+
+// WithObserver 统计等待时间和持有时间，比如传入contention.Profiler的Stats
+func WithObserver(obs contention.Observer) Option {
+	return func(l *FileLock) {
+		l.obs = obs
+	}
+}
+
+// WithStaleHandler 获取锁时，如果发现锁文件中记录的持有者已经不存在了，
+// 说明它没有释放锁就退出了，调用fn。两种模式都会检查，同时获取共享锁的进程
+// 可能都会对同一个PID调用fn。
+func WithStaleHandler(fn func(pid int)) Option {
+	return func(l *FileLock) {
+		l.stale = fn
+	}
+}
+
+// New 创建一个锁住path的FileLock，文件不存在时在加锁时创建
+func New(path string, opts ...Option) *FileLock {
+	l := &FileLock{path: path, poll: defaultPollInterval}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Path 锁文件的路径
+func (l *FileLock) Path() string {
+	return l.path
+}
+
+// Lock 请求锁，直到获取到。打开锁文件失败时panic，需要处理错误时使用LockContext。
+func (l *FileLock) Lock() {
+	if err := l.LockContext(context.Background()); err != nil {
+		panic(err)
+	}
+}
+
+// Unlock 释放锁，失败时panic
+func (l *FileLock) Unlock() {
+	if err := l.Release(); err != nil {
+		panic(err)
+	}
+}
+
+// TryLock 尝试获取锁，不会阻塞
+func (l *FileLock) TryLock() (bool, error) {
+	if !l.mu.TryLock() {
+		return false, nil
+	}
+	start := time.Now()
+	ok, err := l.tryLockFile()
+	if err != nil || !ok {
+		l.mu.Unlock()
+		return false, err
+	}
+	l.acquired(start)
+	return true, nil
+}
+
+// LockContext 请求锁，ctx被取消时返回ctx.Err()。
+// 没有ctx时直接阻塞在flock上，有ctx时按轮询间隔不断尝试。
+func (l *FileLock) LockContext(ctx context.Context) error {
+	start := time.Now()
+	if err := l.mu.LockContext(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	if ctx.Done() == nil {
+		err = l.lockFile(true)
+	} else {
+		err = l.pollLock(ctx)
+	}
+	if err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	l.acquired(start)
+	return nil
+}
+
+func (l *FileLock) pollLock(ctx context.Context) error {
+	ticker := time.NewTicker(l.poll)
+	defer ticker.Stop()
+	for {
+		ok, err := l.tryLockFile()
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *FileLock) tryLockFile() (bool, error) {
+	err := l.lockFile(false)
+	if err == errWouldBlock {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lockFile 打开锁文件并加锁，block为false时锁被占用返回errWouldBlock
+func (l *FileLock) lockFile(block bool) error {
+	flag := os.O_RDWR | os.O_CREATE
+	if l.mode == Shared { // 共享的持有者只追加记录
+		flag |= os.O_APPEND
+	}
+	f, err := os.OpenFile(l.path, flag, 0644)
+	if err != nil {
+		return err
+	}
+	if err := flock(f, l.mode, block); err != nil {
+		f.Close()
+		return err
+	}
+	if err := l.writePID(f); err != nil {
+		unflock(f)
+		f.Close()
+		return err
+	}
+	l.state.Lock()
+	l.f = f
+	l.state.Unlock()
+	return nil
+}
+
+// writePID 检查记录的持有者是否正常释放，然后记录自己的PID
+func (l *FileLock) writePID(f *os.File) error {
+	pids, err := readHolders(f)
+	if err != nil {
+		pids = nil // 记录损坏了，写锁会重写，共享锁继续追加
+	}
+	var dead []int
+	for _, pid := range pids {
+		if pid != os.Getpid() && !processAlive(pid) {
+			dead = append(dead, pid)
+			if l.stale != nil {
+				l.stale(pid)
+			}
+		}
+	}
+
+	self := strconv.Itoa(os.Getpid()) + "\n"
+	if l.mode == Exclusive {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		_, err := f.WriteAt([]byte(self), 0)
+		return err
+	}
+	var b strings.Builder
+	for _, pid := range dead { // 替已经退出的持有者释放，之后不再报告
+		fmt.Fprintf(&b, "-%d\n", pid)
+	}
+	b.WriteString(self)
+	return appendRecord(f, b.String())
+}
+
+// appendRecord 在锁文件末尾追加记录。共享模式以O_APPEND打开，
+// 每条记录一次write调用，和其它共享的持有者的记录不会交错
+func appendRecord(f *os.File, rec string) error {
+	_, err := f.WriteString(rec)
+	return err
+}
+
+func (l *FileLock) acquired(start time.Time) {
+	now := time.Now()
+	l.state.Lock()
+	l.acquiredAt = now
+	l.state.Unlock()
+	if l.obs != nil {
+		l.obs.Acquired(now.Sub(start))
+	}
+}
+
+// Release 释放锁，返回释放过程中的错误
+func (l *FileLock) Release() error {
+	l.state.Lock()
+	f := l.f
+	if f == nil {
+		l.state.Unlock()
+		return fmt.Errorf("filelock: unlock of unlocked lock %s", l.path)
+	}
+	hold := time.Since(l.acquiredAt)
+	l.f = nil
+	l.state.Unlock()
+
+	var err error
+	if l.mode == Exclusive {
+		err = f.Truncate(0) // 正常释放时清掉PID
+	} else {
+		err = appendRecord(f, "-"+strconv.Itoa(os.Getpid())+"\n")
+	}
+	if e := unflock(f); err == nil {
+		err = e
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	l.mu.Unlock()
+	if l.obs != nil {
+		l.obs.Released(hold)
+	}
+	return err
+}
+
+// Holder 锁文件中记录的持有者
+type Holder struct {
+	PID   int
+	Alive bool // 这个进程是否还存在，不存在说明它没有释放锁就退出了
+}
+
+// Holder 读取锁文件中记录的持有者，没有记录时ok为false。有多个共享的持有者时返回第一个。
+func (l *FileLock) Holder() (h Holder, ok bool, err error) {
+	hs, err := l.Holders()
+	if err != nil || len(hs) == 0 {
+		return Holder{}, false, err
+	}
+	return hs[0], true, nil
+}
+
+// Holders 读取锁文件中记录的所有持有者，按获取锁的先后
+func (l *FileLock) Holders() ([]Holder, error) {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pids, err := readHolders(f)
+	if err != nil {
+		return nil, err
+	}
+	hs := make([]Holder, 0, len(pids))
+	for _, pid := range pids {
+		hs = append(hs, Holder{PID: pid, Alive: processAlive(pid)})
+	}
+	return hs, nil
+}
+
+// readHolders 读取锁文件中的记录，返回还没有释放的PID。
+// 同一个进程可以持有多个共享锁，每个"PID"和一个"-PID"抵消。
+func readHolders(f *os.File) ([]int, error) {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<62))
+	if err != nil {
+		return nil, err
+	}
+	var order []int
+	count := make(map[int]int)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("filelock: bad record %q in %s", line, f.Name())
+		}
+		if pid < 0 {
+			if count[-pid] > 0 { // 多个进程可能替同一个退出的持有者释放
+				count[-pid]--
+			}
+			continue
+		}
+		if _, ok := count[pid]; !ok {
+			order = append(order, pid)
+		}
+		count[pid]++
+	}
+	pids := order[:0]
+	for _, pid := range order {
+		if count[pid] > 0 {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
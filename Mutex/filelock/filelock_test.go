@@ -0,0 +1,235 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package filelock
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"GoConcurrentProgramming/Mutex/contention"
+)
+
+var _ sync.Locker = (*FileLock)(nil)
+
+// 作为子进程运行时，获取锁之后输出一行，然后一直持有到stdin关闭
+func TestHelperProcess(t *testing.T) {
+	path := os.Getenv("FILELOCK_HELPER_PATH")
+	if path == "" {
+		return
+	}
+	mode := Exclusive
+	if os.Getenv("FILELOCK_HELPER_MODE") == "shared" {
+		mode = Shared
+	}
+	l := New(path, WithMode(mode))
+	l.Lock()
+	os.Stdout.WriteString("locked\n")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+	if os.Getenv("FILELOCK_HELPER_CRASH") != "" {
+		os.Exit(0) // 不释放锁直接退出，锁文件中留下PID
+	}
+	l.Unlock()
+	os.Exit(0)
+}
+
+// startHolder 启动一个持有锁的子进程，返回让它退出的函数
+func startHolder(t *testing.T, path string, mode Mode, crash bool) (*exec.Cmd, func()) {
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+	cmd.Env = append(os.Environ(), "FILELOCK_HELPER_PATH="+path, "FILELOCK_HELPER_MODE="+mode.String())
+	if crash {
+		cmd.Env = append(cmd.Env, "FILELOCK_HELPER_CRASH=1")
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); err != nil || line != "locked\n" {
+		t.Fatalf("helper failed to lock: %q %v", line, err)
+	}
+	return cmd, func() {
+		stdin.Close()
+		cmd.Wait()
+	}
+}
+
+func TestCrossProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.lock")
+	cmd, stop := startHolder(t, path, Exclusive, false)
+
+	l := New(path, WithPollInterval(time.Millisecond))
+	if ok, err := l.TryLock(); ok || err != nil {
+		t.Fatalf("expect TryLock to fail while another process holds the lock, got %v %v", ok, err)
+	}
+	h, ok, err := l.Holder()
+	if err != nil || !ok || h.PID != cmd.Process.Pid || !h.Alive {
+		t.Fatalf("unexpected holder %+v %v %v", h, ok, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.LockContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded but got %v", err)
+	}
+
+	stop()
+	if err := l.LockContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if h, _, _ := l.Holder(); h.PID != os.Getpid() {
+		t.Fatalf("expect our pid in the lock file, got %d", h.PID)
+	}
+	l.Unlock()
+	if _, ok, _ := l.Holder(); ok {
+		t.Fatal("expect the pid to be cleared after unlock")
+	}
+}
+
+func TestShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.lock")
+	_, stop := startHolder(t, path, Shared, false)
+	defer stop()
+
+	r := New(path, WithMode(Shared))
+	if ok, err := r.TryLock(); !ok || err != nil {
+		t.Fatalf("expect shared lock to succeed, got %v %v", ok, err)
+	}
+	defer r.Unlock()
+
+	w := New(path)
+	if ok, _ := w.TryLock(); ok {
+		t.Fatal("expect exclusive lock to fail with shared holders")
+	}
+}
+
+func TestStaleHolder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.lock")
+	cmd, stop := startHolder(t, path, Exclusive, true)
+	pid := cmd.Process.Pid
+	stop() // 子进程没有释放锁就退出了
+
+	l := New(path)
+	if h, ok, _ := l.Holder(); !ok || h.PID != pid || h.Alive {
+		t.Fatalf("expect a stale holder %d, got %+v", pid, h)
+	}
+
+	var stalePID int
+	l = New(path, WithStaleHandler(func(pid int) { stalePID = pid }))
+	l.Lock() // 进程退出时操作系统已经释放了flock
+	defer l.Unlock()
+	if stalePID != pid {
+		t.Fatalf("expect stale handler to be called with %d, got %d", pid, stalePID)
+	}
+}
+
+// 进程内多个goroutine使用同一个FileLock
+func TestInProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.lock")
+	p := contention.NewProfiler()
+	l := New(path, WithObserver(p.Stats("file")))
+
+	var count int
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				l.Lock()
+				count++
+				l.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 200 {
+		t.Fatalf("expect 200 but got %d", count)
+	}
+	if s := p.Stats("file").Snapshot(); s.Wait.Count != 200 || s.Hold.Count != 200 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// 同一个进程中的两个FileLock也是互斥的
+	other := New(path)
+	l.Lock()
+	if ok, _ := other.TryLock(); ok {
+		t.Fatal("expect the second FileLock to fail")
+	}
+	l.Unlock()
+
+	if err := l.Release(); err == nil {
+		t.Fatal("expect error on unlock of unlocked lock")
+	}
+}
+
+// 共享锁的持有者也会记录PID，没有释放就退出同样能发现
+func TestSharedStaleHolder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.lock")
+	live, stopLive := startHolder(t, path, Shared, false)
+	defer stopLive()
+	dead, stop := startHolder(t, path, Shared, true)
+	stop()
+
+	r := New(path, WithMode(Shared))
+	hs, err := r.Holders()
+	if err != nil || len(hs) != 2 || hs[0] != (Holder{live.Process.Pid, true}) || hs[1] != (Holder{dead.Process.Pid, false}) {
+		t.Fatalf("unexpected holders %+v %v", hs, err)
+	}
+
+	var stale []int
+	r = New(path, WithMode(Shared), WithStaleHandler(func(pid int) { stale = append(stale, pid) }))
+	r.Lock()
+	if len(stale) != 1 || stale[0] != dead.Process.Pid {
+		t.Fatalf("expect stale handler to be called with %d, got %v", dead.Process.Pid, stale)
+	}
+	if hs, _ := r.Holders(); len(hs) != 2 || hs[0].PID != live.Process.Pid || hs[1].PID != os.Getpid() {
+		t.Fatalf("expect the stale holder to be released, got %+v", hs)
+	}
+	r.Unlock()
+
+	// 已经替它释放过了，不会再报告
+	stale = nil
+	r.Lock()
+	r.Unlock()
+	if len(stale) != 0 {
+		t.Fatalf("expect no more stale reports but got %v", stale)
+	}
+	if hs, _ := r.Holders(); len(hs) != 1 || hs[0].PID != live.Process.Pid {
+		t.Fatalf("expect only the live holder, got %+v", hs)
+	}
+}
+
+// 误调用的Release和加锁同时发生时不能有数据竞争
+func TestReleaseRace(t *testing.T) {
+	l := New(filepath.Join(t.TempDir(), "data.lock"))
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if ok, _ := l.TryLock(); ok {
+				l.Release()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			l.Release()
+		}
+	}()
+	wg.Wait()
+}
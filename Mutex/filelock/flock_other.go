@@ -0,0 +1,24 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package filelock
+
+import (
+	"errors"
+	"os"
+)
+
+// 不会被返回，加锁总是得到ErrUnsupported
+var errWouldBlock = errors.New("filelock: would block")
+
+func flock(f *os.File, mode Mode, block bool) error {
+	return ErrUnsupported
+}
+
+func unflock(f *os.File) error {
+	return ErrUnsupported
+}
+
+func processAlive(pid int) bool {
+	return true
+}
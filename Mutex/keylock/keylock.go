@@ -0,0 +1,296 @@
+// Package keylock 按key加锁，比如“锁住用户42”，而不需要一把全局的锁。
+//
+// 和Map中的ConcurrentMap一样，key通过fnv32分散到多个分片，每个分片一把锁，
+// 只在修改key的状态时短暂持有。每个key的状态带有引用计数（持有者加上等待者），
+// 计数归零时从分片中删除，空闲的key不会一直占用内存。
+package keylock
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SHARD_COUNT 默认的分片数量
+var SHARD_COUNT = 32
+
+// KeyedMutex 按key加锁的读写锁
+type KeyedMutex struct {
+	shards []*shard
+}
+
+type shard struct {
+	sync.Mutex
+	keys map[string]*entry
+}
+
+// 一个key的状态，由分片的锁保护
+type entry struct {
+	refs    int       // 持有者和等待者的数量，为0时删除
+	readers int       // 持有读锁的数量
+	writer  bool      // 是否有writer持有写锁
+	waiters list.List // *waiter，先入先出
+}
+
+type waiter struct {
+	exclusive bool
+	granted   bool
+	ch        chan struct{} // 获取到锁时关闭
+}
+
+// New 创建一个KeyedMutex，分片数量为SHARD_COUNT
+func New() *KeyedMutex {
+	return NewWithShards(SHARD_COUNT)
+}
+
+// NewWithShards 创建一个有n个分片的KeyedMutex
+func NewWithShards(n int) *KeyedMutex {
+	if n <= 0 {
+		n = 1
+	}
+	m := &KeyedMutex{shards: make([]*shard, n)}
+	for i := range m.shards {
+		m.shards[i] = &shard{keys: make(map[string]*entry)}
+	}
+	return m
+}
+
+func (m *KeyedMutex) getShard(key string) *shard {
+	return m.shards[uint(fnv32(key))%uint(len(m.shards))]
+}
+
+// 是否可以直接获取到锁
+func (e *entry) canGrant(exclusive bool) bool {
+	if exclusive {
+		return !e.writer && e.readers == 0
+	}
+	return !e.writer
+}
+
+func (e *entry) grant(exclusive bool) {
+	if exclusive {
+		e.writer = true
+	} else {
+		e.readers++
+	}
+}
+
+// wake 按顺序唤醒队头可以获取到锁的waiter，连续的reader会一起被唤醒
+func (e *entry) wake() {
+	for f := e.waiters.Front(); f != nil; f = e.waiters.Front() {
+		w := f.Value.(*waiter)
+		if !e.canGrant(w.exclusive) {
+			return
+		}
+		e.waiters.Remove(f)
+		e.grant(w.exclusive)
+		w.granted = true
+		close(w.ch)
+	}
+}
+
+// acquire 请求key的锁。try为true时不等待，返回是否获取到；否则等待直到获取到锁或者ctx被取消。
+func (m *KeyedMutex) acquire(ctx context.Context, key string, exclusive, try bool) (bool, error) {
+	s := m.getShard(key)
+	s.Lock()
+	e, ok := s.keys[key]
+	if !ok {
+		e = &entry{}
+		s.keys[key] = e
+	}
+	// 有人在排队的时候新来的也要排队，防止writer被源源不断的reader饿死
+	if e.waiters.Len() == 0 && e.canGrant(exclusive) {
+		e.refs++
+		e.grant(exclusive)
+		s.Unlock()
+		return true, nil
+	}
+	if try {
+		if e.refs == 0 {
+			delete(s.keys, key)
+		}
+		s.Unlock()
+		return false, nil
+	}
+	e.refs++
+	w := &waiter{exclusive: exclusive, ch: make(chan struct{})}
+	el := e.waiters.PushBack(w)
+	s.Unlock()
+
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case <-w.ch:
+		return true, nil
+	case <-done:
+	}
+
+	s.Lock()
+	if w.granted { // 取消的同时获取到了锁，释放掉
+		s.Unlock()
+		m.release(key, exclusive)
+		return false, ctx.Err()
+	}
+	e.waiters.Remove(el)
+	e.refs--
+	e.wake() // 排在前面的writer放弃了，后面的reader可能可以获取到锁了
+	if e.refs == 0 {
+		delete(s.keys, key)
+	}
+	s.Unlock()
+	return false, ctx.Err()
+}
+
+func (m *KeyedMutex) release(key string, exclusive bool) {
+	s := m.getShard(key)
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.keys[key]
+	if !ok || (exclusive && !e.writer) || (!exclusive && e.readers == 0) {
+		panic(fmt.Sprintf("keylock: unlock of unlocked key %q", key))
+	}
+	if exclusive {
+		e.writer = false
+	} else {
+		e.readers--
+	}
+	e.refs--
+	e.wake()
+	if e.refs == 0 {
+		delete(s.keys, key)
+	}
+}
+
+// Lock 请求key的写锁
+func (m *KeyedMutex) Lock(key string) {
+	m.acquire(nil, key, true, false)
+}
+
+// Unlock 释放key的写锁
+func (m *KeyedMutex) Unlock(key string) {
+	m.release(key, true)
+}
+
+// TryLock 尝试获取key的写锁，不会阻塞
+func (m *KeyedMutex) TryLock(key string) bool {
+	ok, _ := m.acquire(nil, key, true, true)
+	return ok
+}
+
+// LockContext 请求key的写锁，ctx被取消时返回ctx.Err()，此时没有持有锁
+func (m *KeyedMutex) LockContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := m.acquire(ctx, key, true, false)
+	return err
+}
+
+// RLock 请求key的读锁
+func (m *KeyedMutex) RLock(key string) {
+	m.acquire(nil, key, false, false)
+}
+
+// RUnlock 释放key的读锁
+func (m *KeyedMutex) RUnlock(key string) {
+	m.release(key, false)
+}
+
+// TryRLock 尝试获取key的读锁，不会阻塞
+func (m *KeyedMutex) TryRLock(key string) bool {
+	ok, _ := m.acquire(nil, key, false, true)
+	return ok
+}
+
+// RLockContext 请求key的读锁，ctx被取消时返回ctx.Err()，此时没有持有锁
+func (m *KeyedMutex) RLockContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := m.acquire(ctx, key, false, false)
+	return err
+}
+
+// Locker 返回key的写锁对应的sync.Locker
+func (m *KeyedMutex) Locker(key string) sync.Locker {
+	return &keyLocker{m: m, key: key}
+}
+
+// RLocker 返回key的读锁对应的sync.Locker
+func (m *KeyedMutex) RLocker(key string) sync.Locker {
+	return &keyLocker{m: m, key: key, shared: true}
+}
+
+type keyLocker struct {
+	m      *KeyedMutex
+	key    string
+	shared bool
+}
+
+func (l *keyLocker) Lock() {
+	l.m.acquire(nil, l.key, !l.shared, false)
+}
+
+func (l *keyLocker) Unlock() {
+	l.m.release(l.key, !l.shared)
+}
+
+// Stats 某一时刻的统计信息，各个分片是依次统计的
+type Stats struct {
+	Keys      int // 还有引用的key的数量
+	Locked    int // 被持有的key的数量
+	Exclusive int // 被持有写锁的key的数量
+	Shared    int // 持有的读锁的数量
+	Waiters   int // 正在等待的数量
+}
+
+// Stats 返回统计信息
+func (m *KeyedMutex) Stats() Stats {
+	var st Stats
+	for _, s := range m.shards {
+		s.Lock()
+		st.Keys += len(s.keys)
+		for _, e := range s.keys {
+			if e.writer || e.readers > 0 {
+				st.Locked++
+			}
+			if e.writer {
+				st.Exclusive++
+			}
+			st.Shared += e.readers
+			st.Waiters += e.waiters.Len()
+		}
+		s.Unlock()
+	}
+	return st
+}
+
+// LockedKeys 返回当前被持有的key，按字典序排列
+func (m *KeyedMutex) LockedKeys() []string {
+	var keys []string
+	for _, s := range m.shards {
+		s.Lock()
+		for k, e := range s.keys {
+			if e.writer || e.readers > 0 {
+				keys = append(keys, k)
+			}
+		}
+		s.Unlock()
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	const prime32 = uint32(16777619)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
@@ -0,0 +1,134 @@
+package keylock
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPerKeyExclusion(t *testing.T) {
+	m := New()
+	counts := make([]int, 5)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				k := (i + j) % len(counts)
+				key := "user" + strconv.Itoa(k)
+				m.Lock(key)
+				counts[k]++
+				m.Unlock(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for k, c := range counts {
+		if c != 2000 {
+			t.Fatalf("key %d: expect 2000 but got %d", k, c)
+		}
+	}
+	if st := m.Stats(); st != (Stats{}) {
+		t.Fatalf("expect idle keys to be removed, got %+v", st)
+	}
+}
+
+func TestSharedAndTry(t *testing.T) {
+	m := New()
+	m.RLock("a")
+	m.RLock("a")
+	if !m.TryRLock("a") {
+		t.Fatal("expect TryRLock to succeed with only readers")
+	}
+	if m.TryLock("a") {
+		t.Fatal("expect TryLock to fail with readers")
+	}
+	if !m.TryLock("b") { // 不同的key互不影响
+		t.Fatal("expect TryLock on another key to succeed")
+	}
+
+	st := m.Stats()
+	if st.Keys != 2 || st.Locked != 2 || st.Exclusive != 1 || st.Shared != 3 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if keys := m.LockedKeys(); len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("unexpected locked keys %v", keys)
+	}
+
+	m.RUnlock("a")
+	m.RUnlock("a")
+	m.RUnlock("a")
+	m.Unlock("b")
+	if st := m.Stats(); st != (Stats{}) {
+		t.Fatalf("expect no keys, got %+v", st)
+	}
+}
+
+func TestLockContext(t *testing.T) {
+	m := New()
+	m.RLock("k")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// 排在前面的writer放弃之后，后面的reader要马上获取到锁
+	readerDone := make(chan error)
+	go func() {
+		for m.Stats().Waiters != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		readerDone <- m.RLockContext(context.Background(), "k")
+	}()
+	if err := m.LockContext(ctx, "k"); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded but got %v", err)
+	}
+	if err := <-readerDone; err != nil {
+		t.Fatal(err)
+	}
+
+	st := m.Stats()
+	if st.Keys != 1 || st.Shared != 2 || st.Waiters != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	m.RUnlock("k")
+	m.RUnlock("k")
+	if st := m.Stats(); st != (Stats{}) {
+		t.Fatalf("expect no keys, got %+v", st)
+	}
+}
+
+func TestUnlockOfUnlocked(t *testing.T) {
+	m := New()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic")
+		}
+	}()
+	m.Unlock("nope")
+}
+
+func TestLocker(t *testing.T) {
+	m := NewWithShards(1)
+	l := m.Locker("x")
+	rl := m.RLocker("x")
+
+	l.Lock()
+	done := make(chan struct{})
+	go func() {
+		rl.Lock()
+		rl.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("reader should wait for the writer")
+	case <-time.After(10 * time.Millisecond):
+	}
+	l.Unlock()
+	<-done
+}
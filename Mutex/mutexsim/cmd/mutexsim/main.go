@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"GoConcurrentProgramming/Mutex/mutexsim"
+)
+
+// 在同样的负载下比较四个版本的Mutex和sync.Mutex
+//
+//	go run ./Mutex/mutexsim/cmd/mutexsim -goroutines 64 -cs 5us
+func main() {
+	goroutines := flag.Int("goroutines", 0, "并发的goroutine数量，为0时运行默认的几种负载")
+	duration := flag.Duration("duration", time.Second, "每种实现运行的时间")
+	cs := flag.Duration("cs", 0, "临界区的长度")
+	think := flag.Duration("think", 0, "两次加锁之间在锁外工作的时间")
+	procs := flag.Int("procs", runtime.GOMAXPROCS(0), "GOMAXPROCS")
+	flag.Parse()
+
+	runtime.GOMAXPROCS(*procs)
+	// 复制一份，不修改包级别的DefaultWorkloads
+	workloads := append([]mutexsim.Workload(nil), mutexsim.DefaultWorkloads...)
+	for i := range workloads {
+		workloads[i].Duration = *duration
+	}
+	if *goroutines > 0 {
+		workloads = []mutexsim.Workload{{
+			Name:            "custom",
+			Goroutines:      *goroutines,
+			Duration:        *duration,
+			CriticalSection: *cs,
+			Think:           *think,
+		}}
+	}
+	if _, err := mutexsim.Compare(os.Stdout, mutexsim.Designs, workloads); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,148 @@
+package mutexsim
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"GoConcurrentProgramming/Mutex/contention"
+)
+
+// Design 一种Mutex的实现
+type Design struct {
+	Name string
+	New  func() sync.Locker
+}
+
+// Designs 参与比较的实现，最后一个是标准库的sync.Mutex，作为参照
+var Designs = []Design{
+	{"v1 (2008, FIFO)", func() sync.Locker { return &MutexV1{} }},
+	{"v2 (2011, woken)", func() sync.Locker { return &MutexV2{} }},
+	{"v3 (2015, spin)", func() sync.Locker { return &MutexV3{} }},
+	{"v4 (2016, starvation)", func() sync.Locker { return &MutexV4{} }},
+	{"sync.Mutex", func() sync.Locker { return &sync.Mutex{} }},
+}
+
+// Workload 一种竞争负载：Goroutines个goroutine在Duration时间内不停地加锁，
+// 在锁内忙等CriticalSection，释放锁之后再忙等Think
+type Workload struct {
+	Name            string
+	Goroutines      int
+	Duration        time.Duration
+	CriticalSection time.Duration
+	Think           time.Duration
+}
+
+// DefaultWorkloads 默认的几种负载：临界区很短、临界区较长，以及锁外有工作的情况
+var DefaultWorkloads = []Workload{
+	{Name: "short", Goroutines: 16, Duration: time.Second},
+	{Name: "long", Goroutines: 16, Duration: time.Second, CriticalSection: 10 * time.Microsecond},
+	{Name: "think", Goroutines: 16, Duration: time.Second, CriticalSection: time.Microsecond, Think: 10 * time.Microsecond},
+}
+
+// Result 一次运行的结果
+type Result struct {
+	Design   string
+	Workload string
+	Ops      uint64
+	Elapsed  time.Duration
+	Wait     contention.HistogramSnapshot // 每次Lock的等待时间
+	MinOps   uint64                       // 完成次数最少的goroutine
+	MaxOps   uint64                       // 完成次数最多的goroutine
+}
+
+// Throughput 每秒加锁的次数
+func (r Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Ops) / r.Elapsed.Seconds()
+}
+
+// MaxStarvation 所有waiter中最长的一次等待
+func (r Result) MaxStarvation() time.Duration {
+	return r.Wait.Max
+}
+
+// Fairness 完成次数最少和最多的goroutine之比，1表示完全公平
+func (r Result) Fairness() float64 {
+	if r.MaxOps == 0 {
+		return 0
+	}
+	return float64(r.MinOps) / float64(r.MaxOps)
+}
+
+// busy 忙等d，模拟占用CPU的工作
+func busy(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	for start := time.Now(); time.Since(start) < d; {
+	}
+}
+
+// Run 在负载w下运行d
+func Run(d Design, w Workload) Result {
+	l := d.New()
+	var (
+		hist  contention.Histogram
+		stop  int32
+		wg    sync.WaitGroup
+		perGo = make([]uint64, w.Goroutines)
+	)
+
+	start := time.Now()
+	wg.Add(w.Goroutines)
+	for i := 0; i < w.Goroutines; i++ {
+		go func(i int) {
+			defer wg.Done()
+			var ops uint64
+			for atomic.LoadInt32(&stop) == 0 {
+				t := time.Now()
+				l.Lock()
+				hist.Observe(time.Since(t))
+				busy(w.CriticalSection)
+				l.Unlock()
+				ops++
+				busy(w.Think)
+			}
+			perGo[i] = ops
+		}(i)
+	}
+	time.Sleep(w.Duration)
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	r := Result{Design: d.Name, Workload: w.Name, Elapsed: time.Since(start), Wait: hist.Snapshot()}
+	for i, n := range perGo {
+		r.Ops += n
+		if i == 0 || n < r.MinOps {
+			r.MinOps = n
+		}
+		if n > r.MaxOps {
+			r.MaxOps = n
+		}
+	}
+	return r
+}
+
+// Compare 在每种负载下依次运行所有的实现，把结果输出成表格
+func Compare(out io.Writer, designs []Design, workloads []Workload) ([]Result, error) {
+	var results []Result
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "workload\tdesign\tops/s\tp50 wait\tp99 wait\tp99.9 wait\tmax starvation\tfairness\t")
+	for _, w := range workloads {
+		for _, d := range designs {
+			r := Run(d, w)
+			results = append(results, r)
+			fmt.Fprintf(tw, "%s\t%s\t%.0f\t%v\t%v\t%v\t%v\t%.2f\t\n",
+				w.Name, d.Name, r.Throughput(),
+				r.Wait.Quantile(0.5), r.Wait.Quantile(0.99), r.Wait.Quantile(0.999),
+				r.MaxStarvation(), r.Fairness())
+		}
+	}
+	return results, tw.Flush()
+}
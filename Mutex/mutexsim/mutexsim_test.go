@@ -0,0 +1,82 @@
+package mutexsim
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 每个版本都要保证互斥
+func TestMutualExclusion(t *testing.T) {
+	for _, d := range Designs {
+		t.Run(d.Name, func(t *testing.T) {
+			l := d.New()
+			var count int
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10000; j++ {
+						l.Lock()
+						count++
+						l.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if count != 100000 {
+				t.Fatalf("expect 100000 but got %d", count)
+			}
+		})
+	}
+}
+
+func TestUnlockOfUnlocked(t *testing.T) {
+	for _, l := range []sync.Locker{&MutexV2{}, &MutexV3{}, &MutexV4{}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%T: expect panic", l)
+				}
+			}()
+			l.Unlock()
+		}()
+	}
+}
+
+func TestCompare(t *testing.T) {
+	w := Workload{Name: "test", Goroutines: 8, Duration: 20 * time.Millisecond, CriticalSection: time.Microsecond}
+	var buf bytes.Buffer
+	results, err := Compare(&buf, Designs, []Workload{w})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(Designs) {
+		t.Fatalf("expect %d results but got %d", len(Designs), len(results))
+	}
+	for _, r := range results {
+		if r.Ops == 0 || r.Wait.Count != r.Ops {
+			t.Fatalf("%s: unexpected result %+v", r.Design, r)
+		}
+		if !strings.Contains(buf.String(), r.Design) {
+			t.Fatalf("expect %s in report:\n%s", r.Design, buf.String())
+		}
+	}
+}
+
+func BenchmarkDesigns(b *testing.B) {
+	for _, d := range Designs {
+		b.Run(d.Name, func(b *testing.B) {
+			l := d.New()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					l.Lock()
+					l.Unlock()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Package mutexsim 把Mutex演进过程中的四个版本实现成可以运行的代码，
+// 并提供一个压测工具，在同样的负载下比较它们的吞吐量、等待时间的长尾，
+// 以及waiter最长被饿了多久。
+//
+// runtime中的信号量、自旋和时间函数用户代码无法直接调用，这里用用户态的实现模拟：
+//   - sema：带FIFO等待队列的信号量，支持把waiter放到队头（LIFO）和直接移交；
+//   - canSpin/doSpin：和runtime一样最多自旋4次，每次空转30个循环；
+//   - nanotime：time.Now。
+package mutexsim
+
+import (
+	"container/list"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 和runtime中的 active_spin、active_spin_cnt 一致
+const (
+	activeSpin    = 4
+	activeSpinCnt = 30
+)
+
+// sema 模拟runtime的信号量，释放时有waiter的话直接交给队头的waiter
+type sema struct {
+	mu      sync.Mutex
+	n       uint32
+	waiters list.List // chan struct{}
+}
+
+// acquire 模拟semacquire，lifo为true时排到队头
+func (s *sema) acquire(lifo bool) {
+	s.mu.Lock()
+	if s.n > 0 {
+		s.n--
+		s.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	if lifo {
+		s.waiters.PushFront(ch)
+	} else {
+		s.waiters.PushBack(ch)
+	}
+	s.mu.Unlock()
+	<-ch
+}
+
+// release 模拟semrelease，handoff为true时让出CPU，让被唤醒的waiter尽快运行
+func (s *sema) release(handoff bool) {
+	s.mu.Lock()
+	if e := s.waiters.Front(); e != nil {
+		ch := s.waiters.Remove(e).(chan struct{})
+		s.mu.Unlock()
+		close(ch)
+	} else {
+		s.n++
+		s.mu.Unlock()
+	}
+	if handoff {
+		runtime.Gosched()
+	}
+}
+
+// canSpin 多核并且自旋次数没有超过限制时才自旋
+func canSpin(iter int) bool {
+	return iter < activeSpin && runtime.NumCPU() > 1 && runtime.GOMAXPROCS(0) > 1
+}
+
+var spinSink int32
+
+// doSpin 模拟procyield，空转一小段时间
+func doSpin() {
+	for i := 0; i < activeSpinCnt; i++ {
+		atomic.LoadInt32(&spinSink)
+	}
+}
+
+func nanotime() int64 {
+	return time.Now().UnixNano()
+}
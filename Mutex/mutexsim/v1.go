@@ -0,0 +1,35 @@
+package mutexsim
+
+import "sync/atomic"
+
+// MutexV1 2008年的第一版：key记录持有和等待锁的goroutine的数量，先来先得
+type MutexV1 struct {
+	key  int32 // 锁是否被持有的标识,还记录了当前持有和等待获取锁的 goroutine 的数量。
+	sema sema  // 信号量专用，用以阻塞/唤醒goroutine
+}
+
+// 保证成功在val上增加delta的值
+func xadd(val *int32, delta int32) (new int32) {
+	for {
+		v := atomic.LoadInt32(val)
+		if atomic.CompareAndSwapInt32(val, v, v+delta) {
+			return v + delta
+		}
+	}
+}
+
+// Lock 请求锁
+func (m *MutexV1) Lock() {
+	if xadd(&m.key, 1) == 1 { //标识加1，如果等于1，成功获取到锁
+		return
+	}
+	m.sema.acquire(false) // 否则阻塞等待
+}
+
+// Unlock 释放锁
+func (m *MutexV1) Unlock() {
+	if xadd(&m.key, -1) == 0 { // 将标识减去1，如果等于0，则没有其它等待者
+		return
+	}
+	m.sema.release(false) // 唤醒其它阻塞的goroutine
+}
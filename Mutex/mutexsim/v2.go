@@ -0,0 +1,71 @@
+package mutexsim
+
+import "sync/atomic"
+
+// 四个版本共用的state标记，第二、三版不使用mutexStarving
+const (
+	mutexLocked      = 1 << iota // 锁持有标记
+	mutexWoken                   // 唤醒标记
+	mutexStarving                // 饥饿标记
+	mutexWaiterShift = iota      // 用于计算阻塞等待的waiter数量
+
+	starvationThresholdNs = 1e6
+)
+
+// MutexV2 2011年的版本：新来的goroutine可以和被唤醒的waiter竞争锁
+type MutexV2 struct {
+	state int32
+	sema  sema
+}
+
+// Lock 请求锁
+func (m *MutexV2) Lock() {
+	// Fast path: 幸运case，能够直接获取到锁
+	if atomic.CompareAndSwapInt32(&m.state, 0, mutexLocked) {
+		return
+	}
+
+	awoke := false
+	for {
+		old := atomic.LoadInt32(&m.state)
+		new := old | mutexLocked // 新状态加锁
+		if old&mutexLocked != 0 {
+			new = old + 1<<mutexWaiterShift //锁原状态已加锁，则等待者数量加一
+		}
+		if awoke {
+			// goroutine是被唤醒的，新状态清除唤醒标志
+			new &^= mutexWoken
+		}
+		if atomic.CompareAndSwapInt32(&m.state, old, new) {
+			if old&mutexLocked == 0 { // 锁原状态未加锁，说明是新抢到了锁
+				break
+			}
+			m.sema.acquire(false) // 请求信号量，请求不到则阻塞休眠
+			awoke = true
+		}
+	}
+}
+
+// Unlock 释放锁
+func (m *MutexV2) Unlock() {
+	// Fast path: drop lock bit.
+	new := atomic.AddInt32(&m.state, -mutexLocked) //去掉锁标志
+	if (new+mutexLocked)&mutexLocked == 0 {        //本来就没有加锁
+		panic("sync: unlock of unlocked mutex")
+	}
+
+	old := new
+	for {
+		// 没有等待者，或者有唤醒的goroutine，或者又被别人加了锁，无需唤醒
+		if old>>mutexWaiterShift == 0 || old&(mutexLocked|mutexWoken) != 0 {
+			return
+		}
+		// 将 waiter 数量减 1，并且设置 mutexWoken 标志，准备唤醒一个waiter
+		new = (old - 1<<mutexWaiterShift) | mutexWoken
+		if atomic.CompareAndSwapInt32(&m.state, old, new) {
+			m.sema.release(false)
+			return
+		}
+		old = atomic.LoadInt32(&m.state)
+	}
+}
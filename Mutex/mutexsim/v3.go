@@ -0,0 +1,71 @@
+package mutexsim
+
+import "sync/atomic"
+
+// MutexV3 2015年的版本：获取不到锁时先自旋几次，再进入休眠
+type MutexV3 struct {
+	state int32
+	sema  sema
+}
+
+// Lock 请求锁
+func (m *MutexV3) Lock() {
+	// Fast path: 幸运之路，正好获取到锁
+	if atomic.CompareAndSwapInt32(&m.state, 0, mutexLocked) {
+		return
+	}
+
+	awoke := false
+	iter := 0 //限制自旋次数
+	for {     // 不管是新来的请求锁的goroutine, 还是被唤醒的goroutine，都不断尝试请求锁
+		old := atomic.LoadInt32(&m.state) // 先保存当前锁的状态
+		new := old | mutexLocked          // 新状态设置加锁标志
+		if old&mutexLocked != 0 {         // 锁还没被释放
+			if canSpin(iter) { // 还可以自旋
+				if !awoke && old&mutexWoken == 0 && old>>mutexWaiterShift != 0 &&
+					atomic.CompareAndSwapInt32(&m.state, old, old|mutexWoken) {
+					awoke = true
+				}
+				doSpin()
+				iter++
+				continue // 自旋，返回开头，再次尝试请求锁
+			}
+			new = old + 1<<mutexWaiterShift
+		}
+		if awoke { // 唤醒状态
+			if new&mutexWoken == 0 {
+				panic("sync: inconsistent mutex state")
+			}
+			new &^= mutexWoken // 新状态清除唤醒标记
+		}
+		if atomic.CompareAndSwapInt32(&m.state, old, new) {
+			if old&mutexLocked == 0 { // 旧状态锁已释放，新状态成功持有了锁，直接返回
+				break
+			}
+			m.sema.acquire(false) // 阻塞等待
+			awoke = true          // 被唤醒
+			iter = 0
+		}
+	}
+}
+
+// Unlock 释放锁，和第二版一样
+func (m *MutexV3) Unlock() {
+	new := atomic.AddInt32(&m.state, -mutexLocked)
+	if (new+mutexLocked)&mutexLocked == 0 {
+		panic("sync: unlock of unlocked mutex")
+	}
+
+	old := new
+	for {
+		if old>>mutexWaiterShift == 0 || old&(mutexLocked|mutexWoken) != 0 {
+			return
+		}
+		new = (old - 1<<mutexWaiterShift) | mutexWoken
+		if atomic.CompareAndSwapInt32(&m.state, old, new) {
+			m.sema.release(false)
+			return
+		}
+		old = atomic.LoadInt32(&m.state)
+	}
+}
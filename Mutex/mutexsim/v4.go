@@ -0,0 +1,122 @@
+package mutexsim
+
+import "sync/atomic"
+
+// MutexV4 2016年至今的版本：waiter等待超过1毫秒，Mutex进入饥饿模式，
+// 释放锁时直接交给队头的waiter，新来的goroutine不再抢锁也不再自旋。
+type MutexV4 struct {
+	state int32
+	sema  sema
+}
+
+// Lock 请求锁
+func (m *MutexV4) Lock() {
+	// Fast path: 幸运之路，一下就获取到了锁
+	if atomic.CompareAndSwapInt32(&m.state, 0, mutexLocked) {
+		return
+	}
+	// Slow path：缓慢之路，尝试自旋竞争或饥饿状态下饥饿goroutine竞争
+	m.lockSlow()
+}
+
+func (m *MutexV4) lockSlow() {
+	var waitStartTime int64 // 记录此 goroutine 请求锁的初始时间
+	starving := false       // 此goroutine的饥饿标记
+	awoke := false          // 唤醒标记
+	iter := 0               // 自旋次数
+	old := atomic.LoadInt32(&m.state)
+	for {
+		// 锁是非饥饿状态，锁还没被释放，尝试自旋
+		if old&(mutexLocked|mutexStarving) == mutexLocked && canSpin(iter) {
+			if !awoke && old&mutexWoken == 0 && old>>mutexWaiterShift != 0 &&
+				atomic.CompareAndSwapInt32(&m.state, old, old|mutexWoken) {
+				awoke = true
+			}
+			doSpin()
+			iter++
+			old = atomic.LoadInt32(&m.state) // 再次获取锁的状态，之后会检查是否锁被释放了
+			continue
+		}
+
+		new := old
+		if old&mutexStarving == 0 {
+			new |= mutexLocked // 非饥饿状态，加锁
+		}
+		if old&(mutexLocked|mutexStarving) != 0 {
+			new += 1 << mutexWaiterShift // waiter数量加1
+		}
+		if starving && old&mutexLocked != 0 {
+			new |= mutexStarving // 设置饥饿状态
+		}
+		if awoke {
+			if new&mutexWoken == 0 {
+				panic("sync: inconsistent mutex state")
+			}
+			new &^= mutexWoken // 新状态清除唤醒标记
+		}
+		if atomic.CompareAndSwapInt32(&m.state, old, new) {
+			// 原来锁的状态已释放，并且不是饥饿状态，正常请求到了锁，返回
+			if old&(mutexLocked|mutexStarving) == 0 {
+				break
+			}
+			// 如果以前就在队列里面，加入到队列头
+			queueLifo := waitStartTime != 0
+			if waitStartTime == 0 {
+				waitStartTime = nanotime()
+			}
+			m.sema.acquire(queueLifo)
+			// 唤醒之后检查锁是否应该处于饥饿状态
+			starving = starving || nanotime()-waitStartTime > starvationThresholdNs
+			old = atomic.LoadInt32(&m.state)
+			// 如果锁已经处于饥饿状态，直接抢到锁，返回
+			if old&mutexStarving != 0 {
+				if old&(mutexLocked|mutexWoken) != 0 || old>>mutexWaiterShift == 0 {
+					panic("sync: inconsistent mutex state")
+				}
+				// 加锁并且将waiter数减1
+				delta := int32(mutexLocked - 1<<mutexWaiterShift)
+				if !starving || old>>mutexWaiterShift == 1 {
+					delta -= mutexStarving // 最后一个waiter或者已经不饥饿了，清除饥饿标记
+				}
+				atomic.AddInt32(&m.state, delta)
+				break
+			}
+			awoke = true
+			iter = 0
+		} else {
+			old = atomic.LoadInt32(&m.state)
+		}
+	}
+}
+
+// Unlock 释放锁
+func (m *MutexV4) Unlock() {
+	// Fast path: drop lock bit.
+	new := atomic.AddInt32(&m.state, -mutexLocked)
+	if new != 0 {
+		m.unlockSlow(new)
+	}
+}
+
+func (m *MutexV4) unlockSlow(new int32) {
+	if (new+mutexLocked)&mutexLocked == 0 {
+		panic("sync: unlock of unlocked mutex")
+	}
+	if new&mutexStarving == 0 {
+		old := new
+		for {
+			if old>>mutexWaiterShift == 0 || old&(mutexLocked|mutexWoken|mutexStarving) != 0 {
+				return
+			}
+			new = (old - 1<<mutexWaiterShift) | mutexWoken
+			if atomic.CompareAndSwapInt32(&m.state, old, new) {
+				m.sema.release(false)
+				return
+			}
+			old = atomic.LoadInt32(&m.state)
+		}
+	} else {
+		// 饥饿模式，直接把锁交给队头的waiter
+		m.sema.release(true)
+	}
+}
@@ -0,0 +1,230 @@
+// Package queue 提供有界的阻塞队列，用来替换Mutex中的SliceQueue。
+//
+// SliceQueue队列空的时候返回nil、不会阻塞，q.data[1:]会让底层数组一直被引用，
+// 并且存的是interface{}。这里的Queue是泛型的，底层是一个固定大小的环形缓冲区：
+// 队列满的时候Enqueue阻塞，队列空的时候Dequeue阻塞，都可以通过context取消；
+// Close之后不能再入队，消费者取完剩下的元素之后得到ErrClosed。
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClosed 队列已经关闭：入队时总是返回，出队时在取完剩下的元素之后返回
+var ErrClosed = errors.New("queue: closed")
+
+// Queue 有界的阻塞队列，必须使用New创建
+type Queue[T any] struct {
+	mu     sync.Mutex
+	buf    []T // 环形缓冲区
+	head   int // 队头的位置
+	n      int // 元素的数量
+	closed bool
+
+	// 状态变化时关闭并替换，唤醒所有在上面等待的goroutine。
+	// 用channel而不是sync.Cond，是为了能和ctx.Done()一起select。
+	notEmpty  chan struct{}
+	notFull   chan struct{}
+	consumers int // 等待notEmpty的数量，没有人等待时不需要唤醒
+	producers int // 等待notFull的数量
+}
+
+// New 创建一个容量为capacity的队列
+func New[T any](capacity int) *Queue[T] {
+	if capacity <= 0 {
+		panic("queue: capacity must be positive")
+	}
+	return &Queue[T]{
+		buf:      make([]T, capacity),
+		notEmpty: make(chan struct{}),
+		notFull:  make(chan struct{}),
+	}
+}
+
+// 唤醒等待的消费者，需要持有mu
+func (q *Queue[T]) signalNotEmpty() {
+	if q.consumers == 0 {
+		return
+	}
+	close(q.notEmpty)
+	q.notEmpty = make(chan struct{})
+}
+
+// 唤醒等待的生产者，需要持有mu
+func (q *Queue[T]) signalNotFull() {
+	if q.producers == 0 {
+		return
+	}
+	close(q.notFull)
+	q.notFull = make(chan struct{})
+}
+
+// push 需要持有mu，并且队列没有满
+func (q *Queue[T]) push(v T) {
+	q.buf[(q.head+q.n)%len(q.buf)] = v
+	q.n++
+	q.signalNotEmpty()
+}
+
+// pop 需要持有mu，并且队列不为空
+func (q *Queue[T]) pop() T {
+	var zero T
+	v := q.buf[q.head]
+	q.buf[q.head] = zero // 清空，不要再引用出队的元素
+	q.head = (q.head + 1) % len(q.buf)
+	q.n--
+	return v
+}
+
+// Enqueue 把值放在队尾，队列满的时候阻塞，队列关闭时返回ErrClosed
+func (q *Queue[T]) Enqueue(v T) error {
+	return q.EnqueueContext(context.Background(), v)
+}
+
+// EnqueueContext 和Enqueue一样，ctx被取消时返回ctx.Err()
+func (q *Queue[T]) EnqueueContext(ctx context.Context, v T) error {
+	q.mu.Lock()
+	for {
+		if q.closed {
+			q.mu.Unlock()
+			return ErrClosed
+		}
+		if q.n < len(q.buf) {
+			q.push(v)
+			q.mu.Unlock()
+			return nil
+		}
+		wait := q.notFull
+		q.producers++
+		q.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+		}
+		q.mu.Lock()
+		q.producers--
+		if err := ctx.Err(); err != nil {
+			q.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// TryEnqueue 尝试入队，队列满了或者已经关闭时返回false，不会阻塞
+func (q *Queue[T]) TryEnqueue(v T) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.n == len(q.buf) {
+		return false
+	}
+	q.push(v)
+	return true
+}
+
+// Dequeue 移去队头并返回，队列空的时候阻塞，队列关闭并且取完之后返回ErrClosed
+func (q *Queue[T]) Dequeue() (T, error) {
+	return q.DequeueContext(context.Background())
+}
+
+// DequeueContext 和Dequeue一样，ctx被取消时返回ctx.Err()
+func (q *Queue[T]) DequeueContext(ctx context.Context) (T, error) {
+	if err := q.waitNotEmpty(ctx); err != nil {
+		var zero T
+		return zero, err
+	}
+	v := q.pop()
+	q.signalNotFull()
+	q.mu.Unlock()
+	return v, nil
+}
+
+// TryDequeue 尝试出队，队列空的时候返回false，不会阻塞
+func (q *Queue[T]) TryDequeue() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n == 0 {
+		var zero T
+		return zero, false
+	}
+	v := q.pop()
+	q.signalNotFull()
+	return v, true
+}
+
+// DequeueN 至少等到一个元素，然后一次取出最多n个
+func (q *Queue[T]) DequeueN(n int) ([]T, error) {
+	return q.DequeueNContext(context.Background(), n)
+}
+
+// DequeueNContext 和DequeueN一样，ctx被取消时返回ctx.Err()
+func (q *Queue[T]) DequeueNContext(ctx context.Context, n int) ([]T, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if err := q.waitNotEmpty(ctx); err != nil {
+		return nil, err
+	}
+	if n > q.n {
+		n = q.n
+	}
+	items := make([]T, n)
+	for i := range items {
+		items[i] = q.pop()
+	}
+	q.signalNotFull()
+	q.mu.Unlock()
+	return items, nil
+}
+
+// waitNotEmpty 等到队列不为空，成功返回时持有mu
+func (q *Queue[T]) waitNotEmpty(ctx context.Context) error {
+	q.mu.Lock()
+	for q.n == 0 {
+		if q.closed {
+			q.mu.Unlock()
+			return ErrClosed
+		}
+		wait := q.notEmpty
+		q.consumers++
+		q.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+		}
+		q.mu.Lock()
+		q.consumers--
+		if err := ctx.Err(); err != nil {
+			q.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 关闭队列，唤醒所有阻塞的生产者和消费者。已经在队列中的元素还可以取出。
+func (q *Queue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.signalNotEmpty()
+	q.signalNotFull()
+}
+
+// Len 队列中元素的数量
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// Cap 队列的容量
+func (q *Queue[T]) Cap() int {
+	return len(q.buf)
+}
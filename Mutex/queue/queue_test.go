@@ -0,0 +1,195 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFIFO(t *testing.T) {
+	q := New[int](3)
+	for i := 0; i < 3; i++ {
+		if !q.TryEnqueue(i) {
+			t.Fatalf("expect TryEnqueue(%d) to succeed", i)
+		}
+	}
+	if q.TryEnqueue(3) {
+		t.Fatal("expect TryEnqueue on a full queue to fail")
+	}
+	for i := 0; i < 3; i++ {
+		v, ok := q.TryDequeue()
+		if !ok || v != i {
+			t.Fatalf("expect %d but got %d, %v", i, v, ok)
+		}
+		q.TryEnqueue(i + 3) // 绕过环形缓冲区的末尾
+	}
+	items, err := q.DequeueN(10)
+	if err != nil || len(items) != 3 || items[0] != 3 || items[2] != 5 {
+		t.Fatalf("unexpected DequeueN result %v, %v", items, err)
+	}
+	if _, ok := q.TryDequeue(); ok {
+		t.Fatal("expect TryDequeue on an empty queue to fail")
+	}
+}
+
+// 出队之后不能再引用元素
+func TestNoLeak(t *testing.T) {
+	q := New[*int](2)
+	v := new(int)
+	q.Enqueue(v)
+	q.Dequeue()
+	for _, p := range q.buf {
+		if p != nil {
+			t.Fatal("expect dequeued slot to be cleared")
+		}
+	}
+}
+
+func TestBlocking(t *testing.T) {
+	q := New[int](1)
+	q.Enqueue(1)
+
+	done := make(chan struct{})
+	go func() {
+		q.Enqueue(2) // 队列满了，阻塞
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("expect Enqueue to block on a full queue")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if v, _ := q.Dequeue(); v != 1 {
+		t.Fatalf("expect 1 but got %d", v)
+	}
+	<-done
+	if v, _ := q.Dequeue(); v != 2 {
+		t.Fatalf("expect 2 but got %d", v)
+	}
+}
+
+func TestContext(t *testing.T) {
+	q := New[string](1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.DequeueContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded but got %v", err)
+	}
+	q.Enqueue("a")
+	if err := q.EnqueueContext(ctx, "b"); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded but got %v", err)
+	}
+	if _, err := q.DequeueNContext(ctx, 1); err != nil { // 有元素的时候直接返回
+		t.Fatal(err)
+	}
+	if q.producers != 0 || q.consumers != 0 {
+		t.Fatal("expect cancelled waiters to be gone")
+	}
+}
+
+func TestClose(t *testing.T) {
+	q := New[int](2)
+	q.Enqueue(1)
+	q.Enqueue(2)
+
+	blocked := make(chan error)
+	go func() {
+		blocked <- q.Enqueue(3)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	q.Close()
+	if err := <-blocked; err != ErrClosed {
+		t.Fatalf("expect blocked producer to get ErrClosed, got %v", err)
+	}
+	if q.TryEnqueue(3) {
+		t.Fatal("expect TryEnqueue after Close to fail")
+	}
+
+	// 先取完剩下的元素，再得到ErrClosed
+	for i := 1; i <= 2; i++ {
+		if v, err := q.Dequeue(); err != nil || v != i {
+			t.Fatalf("expect %d but got %d, %v", i, v, err)
+		}
+	}
+	if _, err := q.Dequeue(); err != ErrClosed {
+		t.Fatalf("expect ErrClosed but got %v", err)
+	}
+	if _, err := q.DequeueN(2); err != ErrClosed {
+		t.Fatalf("expect ErrClosed but got %v", err)
+	}
+}
+
+func TestProducersConsumers(t *testing.T) {
+	q := New[int](8)
+	const producers, perProducer = 4, 1000
+
+	var pwg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		pwg.Add(1)
+		go func() {
+			defer pwg.Done()
+			for i := 1; i <= perProducer; i++ {
+				if err := q.Enqueue(i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		pwg.Wait()
+		q.Close()
+	}()
+
+	var mu sync.Mutex
+	var sum, count int
+	var cwg sync.WaitGroup
+	for c := 0; c < 3; c++ {
+		cwg.Add(1)
+		go func(c int) {
+			defer cwg.Done()
+			for {
+				var items []int
+				var err error
+				if c == 0 {
+					items, err = q.DequeueN(5)
+				} else {
+					var v int
+					v, err = q.Dequeue()
+					items = []int{v}
+				}
+				if err == ErrClosed {
+					return
+				}
+				mu.Lock()
+				for _, v := range items {
+					sum += v
+					count++
+				}
+				mu.Unlock()
+			}
+		}(c)
+	}
+	cwg.Wait()
+
+	if count != producers*perProducer || sum != producers*perProducer*(perProducer+1)/2 {
+		t.Fatalf("unexpected count %d sum %d", count, sum)
+	}
+}
+
+func BenchmarkQueue(b *testing.B) {
+	q := New[int](1024)
+	go func() {
+		for {
+			if _, err := q.Dequeue(); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		q.Enqueue(i)
+	}
+	q.Close()
+}
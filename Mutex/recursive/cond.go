@@ -0,0 +1,198 @@
+package recursive
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/petermattis/goid"
+)
+
+// sync.Cond的Wait只会Unlock一次，而持有RecursiveMutex的goroutine可能已经重入了好几层，
+// 所以这里的Wait会先记下重入的层数，完全释放锁，被唤醒后再恢复同样的持有者和层数。
+
+// release 完全释放锁，返回重入的层数
+func (m *RecursiveMutex) release() int32 {
+	gid := goid.Get()
+	if owner := atomic.LoadInt64(&m.owner); owner != gid {
+		panic(fmt.Sprintf("wrong the owner(%d): %d!", owner, gid))
+	}
+	n := m.recursion
+	m.recursion = 0
+	atomic.StoreInt64(&m.owner, -1)
+	m.Mutex.Unlock()
+	return n
+}
+
+// restore 重新获取锁，恢复重入的层数
+func (m *RecursiveMutex) restore(n int32) {
+	m.Mutex.Lock()
+	atomic.StoreInt64(&m.owner, goid.Get())
+	m.recursion = n
+}
+
+// release 完全释放token持有的锁，返回重入的层数
+func (m *TokenRecursiveMutex) release(token int64) int32 {
+	if owner := atomic.LoadInt64(&m.token); owner != token {
+		panic(fmt.Sprintf("wrong the owner(%d): %d!", owner, token))
+	}
+	n := m.recursion
+	m.recursion = 0
+	atomic.StoreInt64(&m.token, 0)
+	m.Mutex.Unlock()
+	return n
+}
+
+// restore 以token重新获取锁，恢复重入的层数
+func (m *TokenRecursiveMutex) restore(token int64, n int32) {
+	m.Mutex.Lock()
+	atomic.StoreInt64(&m.token, token)
+	m.recursion = n
+}
+
+// notifyList 等待通知的waiter队列，先入先出
+type notifyList struct {
+	mu      sync.Mutex
+	waiters list.List // *notifyWaiter
+}
+
+type notifyWaiter struct {
+	ch       chan struct{}
+	notified bool
+}
+
+// add 在释放锁之前加入队列，这样释放锁之后发出的通知不会丢失
+func (l *notifyList) add() *list.Element {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waiters.PushBack(&notifyWaiter{ch: make(chan struct{})})
+}
+
+// wait 等待通知或者ctx被取消。被取消的waiter如果同时收到了通知，把通知转交给下一个waiter。
+func (l *notifyList) wait(ctx context.Context, e *list.Element) error {
+	w := e.Value.(*notifyWaiter)
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case <-w.ch:
+		return nil
+	case <-done:
+	}
+
+	l.mu.Lock()
+	notified := w.notified
+	if !notified {
+		l.waiters.Remove(e)
+	}
+	l.mu.Unlock()
+	if notified {
+		l.signal()
+	}
+	return ctx.Err()
+}
+
+func (l *notifyList) signal() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e := l.waiters.Front(); e != nil {
+		l.notify(e)
+	}
+}
+
+func (l *notifyList) broadcast() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for e := l.waiters.Front(); e != nil; e = l.waiters.Front() {
+		l.notify(e)
+	}
+}
+
+func (l *notifyList) notify(e *list.Element) {
+	w := l.waiters.Remove(e).(*notifyWaiter)
+	w.notified = true
+	close(w.ch)
+}
+
+// Cond 配合RecursiveMutex使用的条件变量
+type Cond struct {
+	L *RecursiveMutex
+	n notifyList
+}
+
+// NewCond 创建一个使用l的条件变量
+func NewCond(l *RecursiveMutex) *Cond {
+	return &Cond{L: l}
+}
+
+// Wait 完全释放c.L，等待通知，返回前以同样的重入层数重新持有c.L
+func (c *Cond) Wait() {
+	c.wait(nil)
+}
+
+// WaitContext 和Wait一样，ctx被取消时返回ctx.Err()，返回时总是持有c.L
+func (c *Cond) WaitContext(ctx context.Context) error {
+	return c.wait(ctx)
+}
+
+// ctx为nil时不会被取消
+func (c *Cond) wait(ctx context.Context) error {
+	e := c.n.add()
+	n := c.L.release()
+	err := c.n.wait(ctx, e)
+	c.L.restore(n)
+	return err
+}
+
+// Signal 唤醒一个等待的goroutine
+func (c *Cond) Signal() {
+	c.n.signal()
+}
+
+// Broadcast 唤醒所有等待的goroutine
+func (c *Cond) Broadcast() {
+	c.n.broadcast()
+}
+
+// TokenCond 配合TokenRecursiveMutex使用的条件变量
+type TokenCond struct {
+	L *TokenRecursiveMutex
+	n notifyList
+}
+
+// NewTokenCond 创建一个使用l的条件变量
+func NewTokenCond(l *TokenRecursiveMutex) *TokenCond {
+	return &TokenCond{L: l}
+}
+
+// Wait 完全释放token持有的c.L，等待通知，返回前以token和同样的重入层数重新持有c.L
+func (c *TokenCond) Wait(token int64) {
+	c.wait(nil, token)
+}
+
+// WaitContext 和Wait一样，ctx被取消时返回ctx.Err()，返回时总是持有c.L
+func (c *TokenCond) WaitContext(ctx context.Context, token int64) error {
+	return c.wait(ctx, token)
+}
+
+// ctx为nil时不会被取消
+func (c *TokenCond) wait(ctx context.Context, token int64) error {
+	e := c.n.add()
+	n := c.L.release(token)
+	err := c.n.wait(ctx, e)
+	c.L.restore(token, n)
+	return err
+}
+
+// Signal 唤醒一个等待的goroutine
+func (c *TokenCond) Signal() {
+	c.n.signal()
+}
+
+// Broadcast 唤醒所有等待的goroutine
+func (c *TokenCond) Broadcast() {
+	c.n.broadcast()
+}
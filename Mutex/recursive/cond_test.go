@@ -0,0 +1,112 @@
+package recursive
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/petermattis/goid"
+)
+
+func TestCondRestoresRecursion(t *testing.T) {
+	var mu RecursiveMutex
+	c := NewCond(&mu)
+	ready := false
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mu.Lock()
+		mu.Lock()
+		mu.Lock() // 重入三层
+		for !ready {
+			c.Wait()
+		}
+		if mu.recursion != 3 || mu.owner != goid.Get() {
+			t.Errorf("expect recursion 3 owned by %d, got %d owned by %d", goid.Get(), mu.recursion, mu.owner)
+		}
+		mu.Unlock()
+		mu.Unlock()
+		mu.Unlock()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock() // Wait完全释放了锁，这里才能获取到
+	ready = true
+	c.Signal()
+	mu.Unlock()
+	<-done
+}
+
+func TestCondWaitContext(t *testing.T) {
+	var mu RecursiveMutex
+	c := NewCond(&mu)
+
+	mu.Lock()
+	mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded but got %v", err)
+	}
+	if mu.recursion != 2 {
+		t.Fatalf("expect recursion 2 but got %d", mu.recursion)
+	}
+	mu.Unlock()
+	mu.Unlock()
+
+	if n := c.n.waiters.Len(); n != 0 {
+		t.Fatalf("expect cancelled waiter removed, got %d", n)
+	}
+}
+
+func TestTokenCondBroadcast(t *testing.T) {
+	var mu TokenRecursiveMutex
+	c := NewTokenCond(&mu)
+	var ready bool
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func(token int64) {
+			defer wg.Done()
+			mu.Lock(token)
+			mu.Lock(token)
+			for !ready {
+				c.Wait(token)
+			}
+			if mu.token != token || mu.recursion != 2 {
+				t.Errorf("expect token %d with recursion 2, got %d with %d", token, mu.token, mu.recursion)
+			}
+			mu.Unlock(token)
+			mu.Unlock(token)
+		}(int64(i))
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock(100)
+	ready = true
+	c.Broadcast()
+	mu.Unlock(100)
+	wg.Wait()
+}
+
+// 被取消的waiter收到的通知要转交给其它waiter，不能丢失
+func TestCondSignalNotLost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 20; i++ {
+		var l notifyList
+		e1 := l.add()
+		e2 := l.add()
+
+		l.signal() // 通知e1，但e1同时被取消了
+		if err := l.wait(ctx, e1); err == nil {
+			l.signal() // select选中了通知，e1正常消费了这个通知
+		}
+		if err := l.wait(nil, e2); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
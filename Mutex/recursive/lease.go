@@ -0,0 +1,208 @@
+package recursive
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLeaseExpired 租约已经过期，锁已经被释放了
+	ErrLeaseExpired = errors.New("recursive: lease expired")
+	// ErrNotOwner 锁不是被这个token持有的
+	ErrNotOwner = errors.New("recursive: lock not held by token")
+)
+
+// LeaseMutex 带租约的Token递归锁。
+//
+// TokenRecursiveMutex的持有者崩溃或者忘记释放的话，锁会被一直占着。
+// LeaseMutex加锁时给出一个租约时长，持有者需要在租约到期之前Renew续约，
+// 租约到期时自动释放锁，并把锁交给下一个waiter。之前的持有者再调用Unlock时
+// 返回ErrLeaseExpired，而不是panic。零值可用，token不能为0。
+//
+// 最近maxExpiredTokens个租约过期的token会被记住，更早过期的token再调用Unlock时
+// 返回ErrNotOwner。
+type LeaseMutex struct {
+	mu        sync.Mutex
+	token     int64 // 持有锁的token，0表示没有被持有
+	recursion int32
+	ttl       time.Duration
+	deadline  time.Time
+	timer     *time.Timer
+	gen       uint64    // 每次授予或者续约时加1，过期的定时器据此判断自己是否还有效
+	waiters   list.List // *leaseWaiter，先入先出
+
+	expired     [maxExpiredTokens]int64 // 最近租约过期的token，环形缓冲，用来给它们的Unlock返回ErrLeaseExpired
+	expiredNext int                     // 下一个写入expired的位置
+	expirations uint64                  // 租约过期的次数
+}
+
+// 记住的租约过期的token的数量
+const maxExpiredTokens = 16
+
+type leaseWaiter struct {
+	token int64
+	ttl   time.Duration
+	ch    chan struct{} // 锁交给这个waiter时关闭
+}
+
+// Lock 以token请求锁，租约为ttl，ttl<=0表示不会过期。
+// 已经持有锁的token再次请求时是重入，同时以新的ttl续约。
+func (m *LeaseMutex) Lock(token int64, ttl time.Duration) {
+	if token == 0 {
+		panic("recursive: LeaseMutex token must not be 0")
+	}
+	m.mu.Lock()
+	if m.token == token { // 重入
+		m.recursion++
+		m.lease(ttl)
+		m.mu.Unlock()
+		return
+	}
+	if m.token == 0 {
+		m.grant(token, ttl)
+		m.mu.Unlock()
+		return
+	}
+	w := &leaseWaiter{token: token, ttl: ttl, ch: make(chan struct{})}
+	m.waiters.PushBack(w)
+	m.mu.Unlock()
+	<-w.ch // 释放锁或者租约过期时，锁会直接交给我们
+}
+
+// TryLock 尝试以token获取锁，不会阻塞
+func (m *LeaseMutex) TryLock(token int64, ttl time.Duration) bool {
+	if token == 0 {
+		panic("recursive: LeaseMutex token must not be 0")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.token {
+	case token:
+		m.recursion++
+		m.lease(ttl)
+		return true
+	case 0:
+		m.grant(token, ttl)
+		return true
+	}
+	return false
+}
+
+// Renew 以加锁时的ttl为token续约
+func (m *LeaseMutex) Renew(token int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != token {
+		return m.notHeld(token)
+	}
+	m.lease(m.ttl)
+	return nil
+}
+
+// Unlock 释放token持有的锁。租约已经过期时返回ErrLeaseExpired，
+// 锁被其它token持有或者没有被持有时返回ErrNotOwner。
+func (m *LeaseMutex) Unlock(token int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != token {
+		return m.notHeld(token)
+	}
+	m.recursion--
+	if m.recursion != 0 { // 还没有回退到最初的递归调用
+		return nil
+	}
+	m.release()
+	return nil
+}
+
+func (m *LeaseMutex) notHeld(token int64) error {
+	if token != 0 && m.expiredIndex(token) >= 0 {
+		return ErrLeaseExpired
+	}
+	return ErrNotOwner
+}
+
+// expiredIndex token在expired中的位置，不在时返回-1，需要持有mu
+func (m *LeaseMutex) expiredIndex(token int64) int {
+	for i, t := range m.expired {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
+
+// grant 把锁交给token，需要持有mu
+func (m *LeaseMutex) grant(token int64, ttl time.Duration) {
+	m.token = token
+	m.recursion = 1
+	if i := m.expiredIndex(token); i >= 0 { // 重新拿到了锁，不再是过期的持有者
+		m.expired[i] = 0
+	}
+	m.lease(ttl)
+}
+
+// lease 重新开始一个ttl的租约，需要持有mu
+func (m *LeaseMutex) lease(ttl time.Duration) {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.gen++
+	m.ttl = ttl
+	if ttl <= 0 {
+		m.deadline = time.Time{}
+		return
+	}
+	m.deadline = time.Now().Add(ttl)
+	gen := m.gen
+	m.timer = time.AfterFunc(ttl, func() { m.expire(gen) })
+}
+
+// expire 租约到期，如果期间没有续约，强制释放锁
+func (m *LeaseMutex) expire(gen uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gen != gen || m.token == 0 { // 已经续约或者释放了
+		return
+	}
+	if m.expiredIndex(m.token) < 0 {
+		m.expired[m.expiredNext] = m.token
+		m.expiredNext = (m.expiredNext + 1) % maxExpiredTokens
+	}
+	m.expirations++
+	m.release()
+}
+
+// release 释放锁，有waiter的话直接交给队头的waiter，需要持有mu
+func (m *LeaseMutex) release() {
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.token = 0
+	m.recursion = 0
+	m.deadline = time.Time{}
+	m.gen++
+	if e := m.waiters.Front(); e != nil {
+		w := m.waiters.Remove(e).(*leaseWaiter)
+		m.grant(w.token, w.ttl)
+		close(w.ch)
+	}
+}
+
+// Holder 返回当前持有锁的token和租约的到期时间，没有过期时间时deadline为零值
+func (m *LeaseMutex) Holder() (token int64, deadline time.Time, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.token, m.deadline, m.token != 0
+}
+
+// Expirations 租约过期的次数
+func (m *LeaseMutex) Expirations() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.expirations
+}
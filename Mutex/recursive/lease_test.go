@@ -0,0 +1,162 @@
+package recursive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaseReentrant(t *testing.T) {
+	var m LeaseMutex
+	m.Lock(1, 0)
+	m.Lock(1, 0)
+	if m.TryLock(2, 0) {
+		t.Fatal("expect TryLock by another token to fail")
+	}
+	if err := m.Unlock(2); err != ErrNotOwner {
+		t.Fatalf("expect ErrNotOwner but got %v", err)
+	}
+	if err := m.Unlock(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Unlock(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := m.Holder(); ok {
+		t.Fatal("expect the lock to be free")
+	}
+	if err := m.Unlock(1); err != ErrNotOwner {
+		t.Fatalf("expect ErrNotOwner but got %v", err)
+	}
+}
+
+// 租约到期后锁交给下一个waiter，之前的持有者Unlock得到错误
+func TestLeaseExpire(t *testing.T) {
+	var m LeaseMutex
+	m.Lock(1, 20*time.Millisecond)
+	m.Lock(1, 20*time.Millisecond) // 重入的层数不影响过期
+
+	got := make(chan struct{})
+	go func() {
+		m.Lock(2, 0)
+		close(got)
+	}()
+
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("expect the waiter to get the lock after the lease expired")
+	}
+	if token, _, _ := m.Holder(); token != 2 {
+		t.Fatalf("expect token 2 to hold the lock, got %d", token)
+	}
+	if err := m.Unlock(1); err != ErrLeaseExpired {
+		t.Fatalf("expect ErrLeaseExpired but got %v", err)
+	}
+	if err := m.Renew(1); err != ErrLeaseExpired {
+		t.Fatalf("expect ErrLeaseExpired but got %v", err)
+	}
+	if n := m.Expirations(); n != 1 {
+		t.Fatalf("expect 1 expiration but got %d", n)
+	}
+	if err := m.Unlock(2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 接连过期的几个持有者都得到ErrLeaseExpired
+func TestLeaseExpireChain(t *testing.T) {
+	var m LeaseMutex
+	m.Lock(1, 10*time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		m.Lock(2, 10*time.Millisecond) // 1过期之后拿到锁，然后自己也过期
+		m.Lock(3, 0)
+		close(done)
+	}()
+	<-done
+	for _, token := range []int64{1, 2} {
+		if err := m.Unlock(token); err != ErrLeaseExpired {
+			t.Fatalf("token %d: expect ErrLeaseExpired but got %v", token, err)
+		}
+	}
+	if err := m.Unlock(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只记住最近maxExpiredTokens个
+	for token := int64(10); token < 10+maxExpiredTokens+1; token++ {
+		m.Lock(token, time.Millisecond)
+		for {
+			if holder, _, _ := m.Holder(); holder != token {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if err := m.Unlock(10); err != ErrNotOwner {
+		t.Fatalf("expect the oldest expired token to be forgotten, got %v", err)
+	}
+	if err := m.Unlock(10 + maxExpiredTokens); err != ErrLeaseExpired {
+		t.Fatalf("expect ErrLeaseExpired but got %v", err)
+	}
+}
+
+func TestLeaseRenew(t *testing.T) {
+	var m LeaseMutex
+	m.Lock(1, 30*time.Millisecond)
+	_, first, _ := m.Holder()
+
+	for i := 0; i < 5; i++ { // 持续续约，总时长超过ttl
+		time.Sleep(10 * time.Millisecond)
+		if err := m.Renew(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, deadline, ok := m.Holder()
+	if !ok || token != 1 || !deadline.After(first) {
+		t.Fatalf("expect renewed lease, got token %d deadline %v", token, deadline)
+	}
+	if err := m.Unlock(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 释放之后旧的定时器不能再影响新的持有者
+	m.Lock(3, 0)
+	time.Sleep(40 * time.Millisecond)
+	if token, _, _ := m.Holder(); token != 3 {
+		t.Fatalf("expect token 3 to still hold the lock, got %d", token)
+	}
+	if m.Expirations() != 0 {
+		t.Fatal("expect no expiration")
+	}
+	m.Unlock(3)
+}
+
+func TestLeaseFIFO(t *testing.T) {
+	var m LeaseMutex
+	m.Lock(1, 0)
+
+	order := make(chan int64, 3)
+	for token := int64(2); token <= 4; token++ {
+		go func(token int64) {
+			m.Lock(token, 0)
+			order <- token
+			m.Unlock(token)
+		}(token)
+		for { // 保证按顺序排队
+			m.mu.Lock()
+			n := m.waiters.Len()
+			m.mu.Unlock()
+			if n == int(token-1) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	m.Unlock(1)
+	for want := int64(2); want <= 4; want++ {
+		if got := <-order; got != want {
+			t.Fatalf("expect token %d but got %d", want, got)
+		}
+	}
+}
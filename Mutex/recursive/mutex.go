@@ -0,0 +1,80 @@
+// Package recursive 提供可重入的锁：按goroutine id重入的RecursiveMutex、
+// 按token重入的TokenRecursiveMutex，带租约的LeaseMutex，可重入的读写锁RWMutex，
+// 以及配合它们使用的条件变量Cond和TokenCond。
+package recursive
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/petermattis/goid"
+)
+
+// RecursiveMutex 包装一个Mutex,实现可重入
+type RecursiveMutex struct {
+	sync.Mutex
+	owner     int64 // 当前持有锁的goroutine id
+	recursion int32 // 这个goroutine 重入的次数
+}
+
+func (m *RecursiveMutex) Lock() {
+	gid := goid.Get()
+	// 如果当前持有锁的goroutine就是这次调用的goroutine,说明是重入
+	if atomic.LoadInt64(&m.owner) == gid {
+		m.recursion++
+		return
+	}
+	m.Mutex.Lock() // 其他goroutine会在此阻塞
+	// 获得锁的goroutine第一次调用，记录下它的goroutine id,调用次数加1
+	atomic.StoreInt64(&m.owner, gid)
+	m.recursion = 1
+}
+
+func (m *RecursiveMutex) Unlock() {
+	gid := goid.Get()
+	// 非持有锁的goroutine尝试释放锁，错误的使用
+	if owner := atomic.LoadInt64(&m.owner); owner != gid {
+		panic(fmt.Sprintf("wrong the owner(%d): %d!", owner, gid))
+	}
+	// 调用次数减1
+	m.recursion--
+	if m.recursion != 0 { // 如果这个goroutine还没有完全释放，则直接返回
+		return
+	}
+	// 此goroutine最后一次调用，需要释放锁
+	atomic.StoreInt64(&m.owner, -1) //清空id
+	m.Mutex.Unlock()
+}
+
+// TokenRecursiveMutex Token方式的递归锁
+type TokenRecursiveMutex struct {
+	sync.Mutex
+	token     int64
+	recursion int32
+}
+
+// 请求锁，需要传入token
+func (m *TokenRecursiveMutex) Lock(token int64) {
+	if atomic.LoadInt64(&m.token) == token { //如果传入的token和持有锁的token一致，说明是递归调用
+		m.recursion++
+		return
+	}
+	m.Mutex.Lock() // 传入的token不一致，说明不是递归调用
+	// 抢到锁之后记录这个token
+	atomic.StoreInt64(&m.token, token)
+	m.recursion = 1
+}
+
+// 释放锁
+func (m *TokenRecursiveMutex) Unlock(token int64) {
+	if owner := atomic.LoadInt64(&m.token); owner != token { // 释放其它token持有的锁
+		panic(fmt.Sprintf("wrong the owner(%d): %d!", owner, token))
+	}
+	m.recursion--         // 当前持有这个锁的token释放锁
+	if m.recursion != 0 { // 还没有回退到最初的递归调用
+		return
+	}
+	atomic.StoreInt64(&m.token, 0) // 没有递归调用了，释放锁
+	m.Mutex.Unlock()
+}
@@ -0,0 +1,167 @@
+package recursive
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReentrantMutex(t *testing.T) {
+	var rm RecursiveMutex
+
+	for i := 0; i < 10; i++ {
+		rm.Lock()
+	}
+
+	for i := 0; i < 10; i++ {
+		rm.Unlock()
+	}
+}
+
+func TestTokenRecursiveMutex(t *testing.T) {
+	var m TokenRecursiveMutex
+	m.Lock(1)
+	m.Lock(1)
+	m.Unlock(1)
+	m.Unlock(1)
+	m.Lock(2)
+	m.Unlock(2)
+}
+
+func TestRWMutexReentrant(t *testing.T) {
+	var rw RWMutex
+
+	rw.Lock()
+	rw.Lock()  // 写锁重入
+	rw.RLock() // 持有写锁时请求读锁
+	rw.RLock()
+	if n := rw.ReadRecursion(); n != 2 {
+		t.Fatalf("expect read recursion 2 but got %d", n)
+	}
+	rw.RUnlock()
+	rw.Unlock()
+	rw.Unlock()
+	rw.RUnlock()
+
+	// 已经完全释放，其它goroutine可以获取写锁
+	done := make(chan struct{})
+	go func() {
+		rw.Lock()
+		rw.Unlock()
+		close(done)
+	}()
+	<-done
+}
+
+// 有writer在等待时，持有读锁的goroutine再次请求读锁不能被阻塞
+func TestRWMutexReadReentrantWithPendingWriter(t *testing.T) {
+	var rw RWMutex
+	rw.RLock()
+
+	writerDone := make(chan struct{})
+	go func() {
+		rw.Lock()
+		rw.Unlock()
+		close(writerDone)
+	}()
+	for { // 等待writer开始等待
+		rw.mu.Lock()
+		pending := rw.writer != 0
+		rw.mu.Unlock()
+		if pending {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 新的reader被writer挡住
+	readerDone := make(chan struct{})
+	go func() {
+		rw.RLock()
+		rw.RUnlock()
+		close(readerDone)
+	}()
+
+	rw.RLock() // 重入，不会阻塞
+	rw.RUnlock()
+
+	select {
+	case <-readerDone:
+		t.Fatal("new reader should wait for the pending writer")
+	case <-writerDone:
+		t.Fatal("writer should wait for the reader")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	rw.RUnlock()
+	<-writerDone
+	<-readerDone
+}
+
+func TestRWMutexUpgradePanics(t *testing.T) {
+	var rw RWMutex
+	rw.RLock()
+	defer rw.RUnlock()
+
+	defer func() {
+		r := recover()
+		msg, _ := r.(string)
+		if !strings.Contains(msg, "cannot upgrade") {
+			t.Fatalf("expect upgrade panic but got %v", r)
+		}
+	}()
+	rw.Lock()
+}
+
+func TestRWMutexWrongOwner(t *testing.T) {
+	var rw RWMutex
+	rw.Lock()
+	defer rw.Unlock()
+
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		rw.Unlock()
+	}()
+	if r := <-panicked; r == nil {
+		t.Fatal("expect unlock by other goroutine to panic")
+	}
+}
+
+func TestRWMutexCounter(t *testing.T) {
+	var rw RWMutex
+	var count int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() { // writer，在回调里重入
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rw.Lock()
+				func() {
+					rw.Lock()
+					defer rw.Unlock()
+					count++
+				}()
+				rw.Unlock()
+			}
+		}()
+		go func() { // reader，在回调里重入
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rw.RLock()
+				func() {
+					rw.RLock()
+					defer rw.RUnlock()
+					_ = count
+				}()
+				rw.RUnlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 1000 {
+		t.Fatalf("expect 1000 but got %d", count)
+	}
+}
@@ -0,0 +1,137 @@
+package recursive
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/petermattis/goid"
+)
+
+// RWMutex 可重入的读写锁，零值可用：
+//   - 持有读锁的goroutine可以再次请求读锁，即使有writer在等待也不会阻塞；
+//   - 持有写锁的goroutine可以再次请求写锁，也可以请求读锁；
+//   - 只持有读锁的goroutine请求写锁（读锁升级）会导致死锁，直接panic。
+//
+// writer之间的互斥和写锁的重入交给RecursiveMutex，reader按goroutine分别记录重入的次数。
+type RWMutex struct {
+	w RecursiveMutex // 解决多个writer的竞争，同时实现写锁的重入
+
+	mu      sync.Mutex
+	c       sync.Cond       // L为&mu，等待writer离开或者reader读完
+	writer  int64           // 持有或者正在等待写锁的goroutine id，0表示没有
+	readers map[int64]int32 // goroutine id -> 读锁重入的次数
+}
+
+func (m *RWMutex) cond() *sync.Cond {
+	if m.c.L == nil {
+		m.c.L = &m.mu
+	}
+	return &m.c
+}
+
+// 除了gid，是否还有别的goroutine持有读锁
+func (m *RWMutex) otherReaders(gid int64) bool {
+	n := len(m.readers)
+	if _, ok := m.readers[gid]; ok {
+		n--
+	}
+	return n > 0
+}
+
+// RLock 请求读锁
+func (m *RWMutex) RLock() {
+	gid := goid.Get()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.readers == nil {
+		m.readers = make(map[int64]int32)
+	}
+	// 重入的读锁，或者写锁的持有者请求读锁，直接通过
+	if n := m.readers[gid]; n > 0 || m.writer == gid {
+		m.readers[gid] = n + 1
+		return
+	}
+	// 有writer持有或者等待写锁，新的reader需要等待
+	for m.writer != 0 {
+		m.cond().Wait()
+	}
+	m.readers[gid] = 1
+}
+
+// RUnlock 释放读锁
+func (m *RWMutex) RUnlock() {
+	gid := goid.Get()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := m.readers[gid]
+	if n == 0 {
+		panic(fmt.Sprintf("recursive: RUnlock of unlocked RWMutex by goroutine %d", gid))
+	}
+	if n > 1 {
+		m.readers[gid] = n - 1
+		return
+	}
+	delete(m.readers, gid)
+	if m.writer != 0 { // 可能是writer在等待的最后一个reader
+		m.cond().Broadcast()
+	}
+}
+
+// Lock 请求写锁
+func (m *RWMutex) Lock() {
+	gid := goid.Get()
+	m.mu.Lock()
+	if m.readers[gid] > 0 && m.writer != gid {
+		m.mu.Unlock()
+		panic(fmt.Sprintf("recursive: goroutine %d holds the read lock and cannot upgrade it to the write lock", gid))
+	}
+	m.mu.Unlock()
+
+	m.w.Lock() // 写锁重入的时候不会阻塞
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.writer == gid { // 重入
+		return
+	}
+	// 告诉后来的reader有writer了，然后等待已有的reader读完
+	m.writer = gid
+	for m.otherReaders(gid) {
+		m.cond().Wait()
+	}
+}
+
+// Unlock 释放写锁
+func (m *RWMutex) Unlock() {
+	gid := goid.Get()
+	m.mu.Lock()
+	if m.writer != gid {
+		writer := m.writer
+		m.mu.Unlock()
+		panic(fmt.Sprintf("wrong the owner(%d): %d!", writer, gid))
+	}
+	if m.w.recursion == 1 { // 最后一层写锁，放行等待的reader
+		m.writer = 0
+		m.cond().Broadcast()
+	}
+	m.mu.Unlock()
+	m.w.Unlock()
+}
+
+// RLocker 返回一个用读锁实现的Locker
+func (m *RWMutex) RLocker() sync.Locker {
+	return (*rlocker)(m)
+}
+
+type rlocker RWMutex
+
+func (r *rlocker) Lock()   { (*RWMutex)(r).RLock() }
+func (r *rlocker) Unlock() { (*RWMutex)(r).RUnlock() }
+
+// ReadRecursion 当前goroutine持有读锁的重入次数
+func (m *RWMutex) ReadRecursion() int {
+	gid := goid.Get()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int(m.readers[gid])
+}
@@ -1,33 +1,67 @@
 // 获取等待者的数量等指标
+// 内部的字段通过 rwinfo 包读取，它在init的时候检查sync.RWMutex的结构
 package RWMutex
 
 import (
 	"sync"
-	"sync/atomic"
 	"testing"
-	"unsafe"
+	"time"
+
+	"GoConcurrentProgramming/RWMutex/rwinfo"
 )
 
-type RWMutex struct {
-	w           Mutex  // 互斥锁解决多个writer的竞争
-	writerSem   uint32 // writer信号量
-	readerSem   uint32 // reader信号量
-	readerCount int32  // reader的数量
-	readerWait  int32  // writer等待完成的reader的数量
+// GetReaderCount 持有读锁的reader数量
+func GetReaderCount(rw *sync.RWMutex) int32 {
+	return int32(rwinfo.Get(rw).Readers)
 }
 
-func (m *RWMutex) GetReaderCount() int32 {
-	// readerCount 这个成员变量前有1个mutex+2个uint32
-	readerCount := atomic.LoadInt32((*int32)(unsafe.Pointer(uintptr(unsafe.Pointer(&m)) + unsafe.Sizeof(sync.Mutex{}) + 2*unsafe.Sizeof(uint32(0)))))
-	return readerCount
+// GetReaderWait 等待中的writer还需要等待的reader数量
+func GetReaderWait(rw *sync.RWMutex) int32 {
+	return int32(rwinfo.Get(rw).ReaderWait)
 }
 
-func (m *Mutex) GetReaderWait() int32 {
-	// readerWait 这个成员变量前有1个mutex+2个uint32+1个int32
-	readerWait := atomic.LoadInt32((*int32)(unsafe.Pointer(uintptr(unsafe.Pointer(&m)) + unsafe.Sizeof(sync.Mutex{}) + 2*unsafe.Sizeof(uint32(0)) + unsafe.Sizeof(int32(0)))))
-	return readerWait
+// 等待cond成立，最多等一秒
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
 }
 
 func TestGetInfomations(t *testing.T) {
+	var rw sync.RWMutex
+	const n = 5
+	for i := 0; i < n; i++ {
+		rw.RLock()
+	}
+	if c := GetReaderCount(&rw); c != n {
+		t.Fatalf("expect %d readers but got %d", n, c)
+	}
+	if w := GetReaderWait(&rw); w != 0 {
+		t.Fatalf("expect no reader wait without a writer but got %d", w)
+	}
+
+	// writer被这n个reader阻塞
+	locked := make(chan struct{})
+	go func() {
+		rw.Lock()
+		close(locked)
+	}()
+	waitFor(t, func() bool { return GetReaderWait(&rw) == n })
 
+	for i := n; i > 0; i-- {
+		if w := GetReaderWait(&rw); w != int32(i) {
+			t.Fatalf("expect the writer to wait for %d readers but got %d", i, w)
+		}
+		rw.RUnlock()
+	}
+	<-locked
+	if c, w := GetReaderCount(&rw), GetReaderWait(&rw); c != 0 || w != 0 {
+		t.Fatalf("expect 0 readers after the writer locked but got %d, %d", c, w)
+	}
+	rw.Unlock()
 }
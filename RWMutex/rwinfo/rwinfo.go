@@ -0,0 +1,135 @@
+// Package rwinfo 读取sync.RWMutex内部的状态：持有读锁的reader数量、
+// 等待中的writer还需要等待的reader数量，以及是否有writer。
+//
+// 这些字段是未导出的，只能通过unsafe按偏移量读取。偏移量在init的时候通过反射
+// 从sync.RWMutex的定义中得到，并检查字段的名字和大小，Go的实现改变了结构的话
+// 直接panic，而不是悄悄地返回错误的结果。
+package rwinfo
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// 和sync包中的定义一致，writer请求锁的时候把readerCount减去这个值
+const rwmutexMaxReaders = 1 << 30
+
+// readerCount 和 readerWait 在sync.RWMutex中的偏移量
+var readerCountOffset, readerWaitOffset uintptr
+
+func init() {
+	readerCountOffset = int32Field("readerCount")
+	readerWaitOffset = int32Field("readerWait")
+	check()
+}
+
+// int32Field 检查sync.RWMutex中名为name的字段是int32或者atomic.Int32，返回它的偏移量
+func int32Field(name string) uintptr {
+	t := reflect.TypeOf(sync.RWMutex{})
+	f, ok := t.FieldByName(name)
+	if !ok {
+		panic(fmt.Sprintf("rwinfo: sync.RWMutex has no field %q, unsupported Go version", name))
+	}
+	if f.Type.Size() != 4 || f.Offset%4 != 0 {
+		panic(fmt.Sprintf("rwinfo: sync.RWMutex.%s has type %v, expect a 32-bit integer", name, f.Type))
+	}
+	switch {
+	case f.Type.Kind() == reflect.Int32: // Go 1.19之前
+	case f.Type.Kind() == reflect.Struct && f.Type.PkgPath() == "sync/atomic" && f.Type.Name() == "Int32":
+	default:
+		panic(fmt.Sprintf("rwinfo: sync.RWMutex.%s has type %v, expect int32 or atomic.Int32", name, f.Type))
+	}
+	return f.Offset
+}
+
+// check 用一个真实的RWMutex验证读到的值是不是符合预期
+func check() {
+	var rw sync.RWMutex
+	rw.RLock()
+	rw.RLock()
+	if s := Get(&rw); s.Readers != 2 || s.WriterPending {
+		panic(fmt.Sprintf("rwinfo: unexpected sync.RWMutex layout, got %+v with 2 readers", s))
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	rw.Lock()
+	if s := Get(&rw); s.Readers != 0 || !s.WriterPending || !s.WriterLocked {
+		panic(fmt.Sprintf("rwinfo: unexpected sync.RWMutex layout, got %+v with a writer", s))
+	}
+	rw.Unlock()
+}
+
+func load(rw *sync.RWMutex, offset uintptr) int32 {
+	return atomic.LoadInt32((*int32)(unsafe.Pointer(uintptr(unsafe.Pointer(rw)) + offset)))
+}
+
+// State sync.RWMutex某一时刻的状态。两个字段不是同时读取的，有并发时只是近似值。
+type State struct {
+	Readers        int  // 持有读锁的reader数量
+	ReaderWait     int  // 等待中的writer还需要等待的reader数量
+	BlockedReaders int  // 被writer阻塞的reader数量
+	WriterPending  bool // 有writer持有写锁或者正在等待reader释放
+	WriterLocked   bool // writer已经持有了写锁
+}
+
+// ReaderCount 返回readerCount字段的原始值，有writer时是负数
+func ReaderCount(rw *sync.RWMutex) int32 {
+	return load(rw, readerCountOffset)
+}
+
+// ReaderWait 返回readerWait字段的原始值
+func ReaderWait(rw *sync.RWMutex) int32 {
+	return load(rw, readerWaitOffset)
+}
+
+// Get 返回rw当前的状态
+func Get(rw *sync.RWMutex) State {
+	count := int(ReaderCount(rw))
+	if count >= 0 { // 没有writer
+		return State{Readers: count}
+	}
+	wait := int(ReaderWait(rw))
+	if wait < 0 { // writer设置readerWait之前reader先离开了
+		wait = 0
+	}
+	count += rwmutexMaxReaders // 活跃的reader加上被阻塞的reader
+	blocked := count - wait
+	if blocked < 0 {
+		blocked = 0
+	}
+	return State{
+		Readers:        wait,
+		ReaderWait:     wait,
+		BlockedReaders: blocked,
+		WriterPending:  true,
+		WriterLocked:   wait == 0,
+	}
+}
+
+// RWMutex 扩展的sync.RWMutex，提供读取内部状态的方法
+type RWMutex struct {
+	sync.RWMutex
+}
+
+// State 返回当前的状态
+func (m *RWMutex) State() State {
+	return Get(&m.RWMutex)
+}
+
+// ReaderCount 持有读锁的reader数量
+func (m *RWMutex) ReaderCount() int {
+	return m.State().Readers
+}
+
+// ReaderWait 等待中的writer还需要等待的reader数量
+func (m *RWMutex) ReaderWait() int {
+	return m.State().ReaderWait
+}
+
+// WriterPending 是否有writer持有写锁或者正在等待reader释放
+func (m *RWMutex) WriterPending() bool {
+	return ReaderCount(&m.RWMutex) < 0
+}
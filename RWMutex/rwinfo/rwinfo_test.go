@@ -0,0 +1,65 @@
+package rwinfo
+
+import (
+	"testing"
+	"time"
+)
+
+// 等待cond成立，最多等一秒
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetInfomations(t *testing.T) {
+	var rw RWMutex
+	if s := rw.State(); s != (State{}) {
+		t.Fatalf("expect zero state but got %+v", s)
+	}
+
+	// 3个reader持有读锁
+	for i := 0; i < 3; i++ {
+		rw.RLock()
+	}
+	if n := rw.ReaderCount(); n != 3 {
+		t.Fatalf("expect 3 readers but got %d", n)
+	}
+
+	// writer等待这3个reader
+	locked := make(chan struct{})
+	go func() {
+		rw.Lock()
+		close(locked)
+	}()
+	waitFor(t, rw.WriterPending)
+	waitFor(t, func() bool { return rw.ReaderWait() == 3 })
+
+	// 新来的reader被writer阻塞
+	go func() {
+		rw.RLock()
+		rw.RUnlock()
+	}()
+	waitFor(t, func() bool { return rw.State().BlockedReaders == 1 })
+
+	rw.RUnlock()
+	if s := rw.State(); s.Readers != 2 || s.ReaderWait != 2 || s.WriterLocked {
+		t.Fatalf("unexpected state %+v", s)
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	<-locked
+
+	s := rw.State()
+	if !s.WriterPending || !s.WriterLocked || s.Readers != 0 || s.BlockedReaders != 1 {
+		t.Fatalf("unexpected state %+v", s)
+	}
+
+	rw.Unlock()
+	waitFor(t, func() bool { return rw.State() == State{} })
+}
@@ -0,0 +1,262 @@
+// Package rwlock 提供可以选择读写优先策略的读写锁。
+//
+// sync.RWMutex是写优先的：有writer在等待时，readerCount是负值，新来的reader
+// 都会被阻塞，所以writer不会饿死，但是写很频繁的时候reader可能一直拿不到锁。
+// 这里的RWMutex可以在创建时选择策略：
+//
+//   - WriterPreferred 写优先，和sync.RWMutex一样，writer不会饿死
+//   - ReaderPreferred 读优先，有reader持有锁时新来的reader直接进入，reader不会饿死
+//   - PhaseFair 读写阶段交替：writer释放锁时等待的reader全部进入，这些reader
+//     释放之后再轮到下一个writer，reader和writer都不会饿死
+//
+// UpgradableRWMutex 提供可升级的读锁，可以原子地升级为写锁，写锁也可以降级为读锁。
+//
+// 两种读写锁都提供TryLock和支持context的LockContext。sync.RWMutex的writer一旦
+// 把readerCount变成负值，后来的reader都阻塞在readerSem上，直到这个writer拿到锁
+// 再释放；这里放弃等待的writer会从队列中移除，排在它后面的reader立即被放行。
+package rwlock
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Policy 读写锁的优先策略
+type Policy int
+
+const (
+	WriterPreferred Policy = iota // 写优先
+	ReaderPreferred               // 读优先
+	PhaseFair                     // 读写阶段交替
+)
+
+func (p Policy) String() string {
+	switch p {
+	case WriterPreferred:
+		return "writer-preferred"
+	case ReaderPreferred:
+		return "reader-preferred"
+	case PhaseFair:
+		return "phase-fair"
+	}
+	return "unknown"
+}
+
+// RWMutex 可以选择优先策略的读写锁，零值是写优先的读写锁
+type RWMutex struct {
+	policy Policy
+
+	mu         sync.Mutex
+	readers    int           // 持有读锁的reader数量
+	writer     bool          // 是否有writer持有锁
+	rwait      int           // 等待的reader数量
+	rbatch     chan struct{} // 等待的reader在上面阻塞，关闭时它们一起获得读锁
+	writers    list.List     // 等待的writer，先入先出，chan struct{}，关闭时获得写锁
+	lastWriter bool          // 上一个持有锁的是writer，PhaseFair下接下来轮到reader
+}
+
+// New 创建一个使用policy策略的读写锁
+func New(policy Policy) *RWMutex {
+	return &RWMutex{policy: policy}
+}
+
+// Policy 返回读写锁的优先策略
+func (rw *RWMutex) Policy() Policy {
+	return rw.policy
+}
+
+// canRead 新来的reader能否直接获得读锁，需要持有mu
+func (rw *RWMutex) canRead() bool {
+	if rw.writer {
+		return false
+	}
+	// 读优先时不管有没有writer在等待；其它策略下有writer在等待时新来的reader要排队
+	return rw.policy == ReaderPreferred || rw.writers.Len() == 0
+}
+
+// RLock 请求读锁
+func (rw *RWMutex) RLock() {
+	rw.RLockContext(context.Background())
+}
+
+// TryRLock 尝试获取读锁，不会阻塞
+func (rw *RWMutex) TryRLock() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if !rw.canRead() {
+		return false
+	}
+	rw.readers++
+	return true
+}
+
+// RLockContext 请求读锁，ctx被取消时返回ctx.Err()
+func (rw *RWMutex) RLockContext(ctx context.Context) error {
+	rw.mu.Lock()
+	if rw.canRead() {
+		rw.readers++
+		rw.mu.Unlock()
+		return nil
+	}
+	if rw.rbatch == nil {
+		rw.rbatch = make(chan struct{})
+	}
+	ch := rw.rbatch
+	rw.rwait++
+	rw.mu.Unlock()
+
+	select {
+	case <-ch: // 被唤醒时已经计入readers
+		return nil
+	case <-ctx.Done():
+	}
+
+	rw.mu.Lock()
+	select {
+	case <-ch: // 取消的同时已经获得了读锁，只能再释放掉
+		rw.mu.Unlock()
+		rw.RUnlock()
+	default:
+		rw.rwait--
+		rw.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+// RUnlock 释放读锁
+func (rw *RWMutex) RUnlock() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.readers == 0 {
+		panic("rwlock: RUnlock of unlocked RWMutex")
+	}
+	rw.readers--
+	if rw.readers == 0 {
+		rw.lastWriter = false // 读阶段结束了
+		rw.dispatch()
+	}
+}
+
+// Lock 请求写锁
+func (rw *RWMutex) Lock() {
+	rw.LockContext(context.Background())
+}
+
+// canLock 新来的writer能否直接获得写锁，需要持有mu
+func (rw *RWMutex) canLock() bool {
+	return !rw.writer && rw.readers == 0 && rw.writers.Len() == 0
+}
+
+// TryLock 尝试获取写锁，不会阻塞
+func (rw *RWMutex) TryLock() bool {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if !rw.canLock() {
+		return false
+	}
+	rw.writer = true
+	return true
+}
+
+// LockContext 请求写锁，ctx被取消时返回ctx.Err()。
+// 放弃等待的writer不会再占着位置，排在它后面的reader会立即被放行。
+func (rw *RWMutex) LockContext(ctx context.Context) error {
+	rw.mu.Lock()
+	if rw.canLock() {
+		rw.writer = true
+		rw.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	e := rw.writers.PushBack(ch)
+	rw.mu.Unlock()
+
+	select {
+	case <-ch: // 被唤醒时已经持有写锁
+		return nil
+	case <-ctx.Done():
+	}
+
+	rw.mu.Lock()
+	select {
+	case <-ch: // 取消的同时已经获得了写锁，只能再释放掉
+		rw.mu.Unlock()
+		rw.Unlock()
+	default:
+		rw.writers.Remove(e)
+		rw.dispatch() // 因为这个writer而阻塞的reader可以进入了
+		rw.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+// Unlock 释放写锁
+func (rw *RWMutex) Unlock() {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if !rw.writer {
+		panic("rwlock: Unlock of unlocked RWMutex")
+	}
+	rw.writer = false
+	rw.lastWriter = true
+	rw.dispatch()
+}
+
+// dispatch 锁被释放之后，按照策略把锁交给等待的reader或者writer，需要持有mu
+func (rw *RWMutex) dispatch() {
+	if rw.writer {
+		return
+	}
+	switch rw.policy {
+	case ReaderPreferred:
+		if rw.rwait > 0 {
+			rw.grantReaders()
+			return
+		}
+	case PhaseFair:
+		// writer刚释放锁，或者没有writer在等待，等待的reader一起进入读阶段
+		if rw.rwait > 0 && (rw.lastWriter || rw.writers.Len() == 0) {
+			rw.grantReaders()
+			return
+		}
+	default:
+		if rw.writers.Len() == 0 && rw.rwait > 0 {
+			rw.grantReaders()
+			return
+		}
+	}
+	if rw.readers == 0 {
+		rw.grantWriter()
+	}
+}
+
+// grantReaders 所有等待的reader一起获得读锁，需要持有mu
+func (rw *RWMutex) grantReaders() {
+	rw.readers += rw.rwait
+	rw.rwait = 0
+	rw.lastWriter = false
+	close(rw.rbatch)
+	rw.rbatch = nil
+}
+
+// grantWriter 队头的writer获得写锁，需要持有mu
+func (rw *RWMutex) grantWriter() {
+	e := rw.writers.Front()
+	if e == nil {
+		return
+	}
+	ch := rw.writers.Remove(e).(chan struct{})
+	rw.writer = true
+	close(ch)
+}
+
+// RLocker 返回一个Locker，Lock/Unlock调用RLock/RUnlock
+func (rw *RWMutex) RLocker() sync.Locker {
+	return (*rlocker)(rw)
+}
+
+type rlocker RWMutex
+
+func (r *rlocker) Lock()   { (*RWMutex)(r).RLock() }
+func (r *rlocker) Unlock() { (*RWMutex)(r).RUnlock() }
@@ -0,0 +1,304 @@
+package rwlock
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var policies = []Policy{WriterPreferred, ReaderPreferred, PhaseFair}
+
+// waitFor 等到有readers个reader和writers个writer在排队
+func waitFor(rw *RWMutex, readers, writers int) {
+	for {
+		rw.mu.Lock()
+		ok := rw.rwait == readers && rw.writers.Len() == writers
+		rw.mu.Unlock()
+		if ok {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestCounter(t *testing.T) {
+	for _, p := range policies {
+		t.Run(p.String(), func(t *testing.T) {
+			rw := New(p)
+			var count, reads int64
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 1000; j++ {
+						rw.Lock()
+						count++
+						rw.Unlock()
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 1000; j++ {
+						rw.RLock()
+						_ = count
+						atomic.AddInt64(&reads, 1)
+						rw.RUnlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if count != 10000 || reads != 10000 {
+				t.Fatalf("expect 10000 writes and reads but got %d, %d", count, reads)
+			}
+		})
+	}
+}
+
+func TestUnlockOfUnlocked(t *testing.T) {
+	for _, f := range []func(*RWMutex){(*RWMutex).Unlock, (*RWMutex).RUnlock} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expect panic")
+				}
+			}()
+			f(&RWMutex{})
+		}()
+	}
+}
+
+// 写优先：有writer在等待时，新来的reader要排在它后面
+func TestWriterPreferred(t *testing.T) {
+	var rw RWMutex
+	rw.RLock()
+	var order []string
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		rw.Lock()
+		order = append(order, "w")
+		rw.Unlock()
+	}()
+	waitFor(&rw, 0, 1)
+	go func() {
+		defer wg.Done()
+		rw.RLock()
+		order = append(order, "r")
+		rw.RUnlock()
+	}()
+	waitFor(&rw, 1, 1)
+	rw.RUnlock()
+	wg.Wait()
+	if order[0] != "w" || order[1] != "r" {
+		t.Fatalf("expect [w r] but got %v", order)
+	}
+}
+
+// 读优先：有reader持有锁时，新来的reader不管有没有writer在等待都能进入
+func TestReaderPreferred(t *testing.T) {
+	rw := New(ReaderPreferred)
+	rw.RLock()
+	done := make(chan struct{})
+	go func() {
+		rw.Lock()
+		rw.Unlock()
+		close(done)
+	}()
+	waitFor(rw, 0, 1)
+	rw.RLock() // 不会阻塞
+	rw.RUnlock()
+	rw.RUnlock()
+	<-done
+}
+
+// 阶段公平：writer释放锁时，等待的reader先于后面的writer进入
+func TestPhaseFairAlternates(t *testing.T) {
+	rw := New(PhaseFair)
+	rw.Lock()
+	var mu sync.Mutex
+	var order []string
+	record := func(s string) {
+		mu.Lock()
+		order = append(order, s)
+		mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rw.Lock()
+		record("w")
+		rw.Unlock()
+	}()
+	waitFor(rw, 0, 1)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw.RLock()
+			record("r")
+			rw.RUnlock()
+		}()
+	}
+	waitFor(rw, 3, 1)
+	rw.Unlock()
+	wg.Wait()
+	if order[3] != "w" {
+		t.Fatalf("expect readers before the second writer but got %v", order)
+	}
+}
+
+// stress 混合读写，stop关闭之前reader和writer不停地请求锁，返回每一方最长的等待时间
+func stress(rw *RWMutex, readers, writers int, d time.Duration) (maxRead, maxWrite time.Duration, reads, writes int64) {
+	stop := make(chan struct{})
+	var mu sync.Mutex
+	update := func(max *time.Duration, n *int64, wait time.Duration) {
+		mu.Lock()
+		if wait > *max {
+			*max = wait
+		}
+		*n++
+		mu.Unlock()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				start := time.Now()
+				rw.RLock()
+				update(&maxRead, &reads, time.Since(start))
+				time.Sleep(100 * time.Microsecond)
+				rw.RUnlock()
+			}
+		}()
+	}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				start := time.Now()
+				rw.Lock()
+				update(&maxWrite, &writes, time.Since(start))
+				time.Sleep(100 * time.Microsecond)
+				rw.Unlock()
+			}
+		}()
+	}
+	time.Sleep(d)
+	close(stop)
+	wg.Wait()
+	return
+}
+
+// 每种策略在混合读写的压力下都不会饿死它保证的一方
+func TestNoStarvation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping stress test in short mode")
+	}
+	const bound = 200 * time.Millisecond // 远大于一次持有的时间
+	for _, p := range policies {
+		t.Run(p.String(), func(t *testing.T) {
+			maxRead, maxWrite, reads, writes := stress(New(p), 16, 2, 300*time.Millisecond)
+			t.Logf("reads %d (max wait %v), writes %d (max wait %v)", reads, maxRead, writes, maxWrite)
+			if p != ReaderPreferred && (writes == 0 || maxWrite > bound) {
+				t.Errorf("writers starved: %d writes, max wait %v", writes, maxWrite)
+			}
+			if p != WriterPreferred && (reads == 0 || maxRead > bound) {
+				t.Errorf("readers starved: %d reads, max wait %v", reads, maxRead)
+			}
+		})
+	}
+}
+
+func TestTryLock(t *testing.T) {
+	for _, p := range policies {
+		rw := New(p)
+		if !rw.TryRLock() || !rw.TryRLock() {
+			t.Fatalf("%v: TryRLock on read-locked mutex should succeed", p)
+		}
+		if rw.TryLock() {
+			t.Fatalf("%v: TryLock on read-locked mutex should fail", p)
+		}
+		rw.RUnlock()
+		rw.RUnlock()
+		if !rw.TryLock() {
+			t.Fatalf("%v: TryLock on unlocked mutex should succeed", p)
+		}
+		if rw.TryRLock() || rw.TryLock() {
+			t.Fatalf("%v: TryRLock/TryLock on write-locked mutex should fail", p)
+		}
+		rw.Unlock()
+	}
+}
+
+func TestLockContextTimeout(t *testing.T) {
+	for _, p := range policies {
+		rw := New(p)
+		rw.Lock()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if err := rw.RLockContext(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("%v: expect DeadlineExceeded but got %v", p, err)
+		}
+		if err := rw.LockContext(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("%v: expect DeadlineExceeded but got %v", p, err)
+		}
+		cancel()
+		rw.Unlock()
+		// 放弃的请求不会留下痕迹
+		if !rw.TryLock() {
+			t.Fatalf("%v: abandoned requests left the mutex locked", p)
+		}
+		rw.Unlock()
+	}
+}
+
+// writer放弃等待时，排在它后面的reader立即被放行
+func TestCancelledWriterReleasesReaders(t *testing.T) {
+	for _, p := range []Policy{WriterPreferred, PhaseFair} {
+		t.Run(p.String(), func(t *testing.T) {
+			rw := New(p)
+			rw.RLock()
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error)
+			go func() { errc <- rw.LockContext(ctx) }()
+			waitFor(rw, 0, 1)
+
+			readerDone := make(chan struct{})
+			go func() {
+				rw.RLock()
+				rw.RUnlock()
+				close(readerDone)
+			}()
+			waitFor(rw, 1, 1)
+
+			cancel()
+			if err := <-errc; err != context.Canceled {
+				t.Fatalf("expect Canceled but got %v", err)
+			}
+			select {
+			case <-readerDone:
+			case <-time.After(time.Second):
+				t.Fatal("reader still blocked after the writer gave up")
+			}
+			rw.RUnlock()
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package rwlock
+
+import (
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/petermattis/goid"
+)
+
+// 缓存行的大小，按128字节对齐，避免相邻缓存行预取造成的伪共享
+const cacheLineSize = 128
+
+// paddedRWMutex 独占一个缓存行的读写锁
+type paddedRWMutex struct {
+	sync.RWMutex
+	_ [cacheLineSize - unsafe.Sizeof(sync.RWMutex{})%cacheLineSize]byte
+}
+
+// ShardedRWMutex 分片的读写锁（big reader lock），适合读多写少的场景。
+//
+// sync.RWMutex的每次RLock/RUnlock都要原子地修改同一个readerCount，
+// 很多goroutine同时读的时候，这个字所在的缓存行在CPU之间来回传递，
+// 读锁本身成了热点。ShardedRWMutex有多个分片，每个分片独占一个缓存行，
+// reader只修改自己的分片；writer要按顺序锁住所有分片，所以写的代价是分片数倍，
+// 写多的场景不要使用。
+//
+// reader按goroutine id选择分片，取goroutine id只需要几纳秒，
+// 并发的reader分散在不同的分片上。所以和sync.RWMutex不同，
+// RUnlock必须和RLock在同一个goroutine中调用。必须使用NewSharded创建。
+type ShardedRWMutex struct {
+	shards []paddedRWMutex
+	mask   uint64
+}
+
+// NewSharded 创建一个ShardedRWMutex，n是分片数，会向上取整为2的幂，n<=0时使用GOMAXPROCS
+func NewSharded(n int) *ShardedRWMutex {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	return &ShardedRWMutex{
+		shards: make([]paddedRWMutex, size),
+		mask:   uint64(size - 1),
+	}
+}
+
+// Shards 分片的数量
+func (rw *ShardedRWMutex) Shards() int {
+	return len(rw.shards)
+}
+
+// shard 当前goroutine使用的分片
+func (rw *ShardedRWMutex) shard() *paddedRWMutex {
+	return &rw.shards[uint64(goid.Get())&rw.mask]
+}
+
+// RLock 请求读锁，只锁住当前goroutine对应的分片
+func (rw *ShardedRWMutex) RLock() {
+	rw.shard().RLock()
+}
+
+// RUnlock 释放读锁，必须在调用RLock的goroutine中调用
+func (rw *ShardedRWMutex) RUnlock() {
+	rw.shard().RUnlock()
+}
+
+// TryRLock 尝试获取读锁，不会阻塞
+func (rw *ShardedRWMutex) TryRLock() bool {
+	return rw.shard().TryRLock()
+}
+
+// Lock 请求写锁，按顺序锁住所有分片
+func (rw *ShardedRWMutex) Lock() {
+	for i := range rw.shards {
+		rw.shards[i].Lock()
+	}
+}
+
+// Unlock 释放写锁
+func (rw *ShardedRWMutex) Unlock() {
+	for i := len(rw.shards) - 1; i >= 0; i-- {
+		rw.shards[i].Unlock()
+	}
+}
+
+// TryLock 尝试获取写锁，不会阻塞。有分片锁不上时释放已经锁住的分片。
+func (rw *ShardedRWMutex) TryLock() bool {
+	for i := range rw.shards {
+		if !rw.shards[i].TryLock() {
+			for j := i - 1; j >= 0; j-- {
+				rw.shards[j].Unlock()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// RLocker 返回一个Locker，Lock/Unlock调用RLock/RUnlock
+func (rw *ShardedRWMutex) RLocker() sync.Locker {
+	return (*shardedRLocker)(rw)
+}
+
+type shardedRLocker ShardedRWMutex
+
+func (r *shardedRLocker) Lock()   { (*ShardedRWMutex)(r).RLock() }
+func (r *shardedRLocker) Unlock() { (*ShardedRWMutex)(r).RUnlock() }
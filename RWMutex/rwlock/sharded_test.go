@@ -0,0 +1,162 @@
+package rwlock
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestPaddedSize(t *testing.T) {
+	if size := unsafe.Sizeof(paddedRWMutex{}); size%cacheLineSize != 0 {
+		t.Fatalf("expect size to be a multiple of %d but got %d", cacheLineSize, size)
+	}
+}
+
+func TestShardedCounter(t *testing.T) {
+	rw := NewSharded(0)
+	var count int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				rw.Lock()
+				count++
+				rw.Unlock()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				rw.RLock()
+				_ = count
+				rw.RUnlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 10000 {
+		t.Fatalf("expect 10000 but got %d", count)
+	}
+}
+
+func TestShardedTryLock(t *testing.T) {
+	rw := NewSharded(5)
+	if rw.Shards() != 8 {
+		t.Fatalf("expect 8 shards but got %d", rw.Shards())
+	}
+	rw.RLock()
+	if rw.TryLock() {
+		t.Fatal("TryLock should fail while a reader holds a shard")
+	}
+	if !rw.TryRLock() {
+		t.Fatal("TryRLock should succeed while only readers hold the lock")
+	}
+	rw.RUnlock()
+	rw.RUnlock()
+	if !rw.TryLock() {
+		t.Fatal("TryLock on unlocked mutex should succeed")
+	}
+	done := make(chan bool)
+	go func() { // 任何分片上的reader都进不来
+		done <- rw.TryRLock()
+	}()
+	if <-done {
+		t.Fatal("TryRLock should fail while write-locked")
+	}
+	rw.Unlock()
+}
+
+// 不同的goroutine分散在不同的分片上，同一个goroutine总是使用同一个分片
+func TestShardedSpread(t *testing.T) {
+	rw := NewSharded(8)
+	if rw.shard() != rw.shard() {
+		t.Fatal("expect the same shard in one goroutine")
+	}
+	seen := make(map[*paddedRWMutex]bool)
+	for i := 0; i < 64; i++ {
+		ch := make(chan *paddedRWMutex)
+		go func() { ch <- rw.shard() }()
+		seen[<-ch] = true
+	}
+	if len(seen) < 4 {
+		t.Fatalf("expect readers to spread over shards but used %d of 8", len(seen))
+	}
+}
+
+func TestShardedRLocker(t *testing.T) {
+	rw := NewSharded(4)
+	l := rw.RLocker()
+	l.Lock()
+	if rw.TryLock() {
+		t.Fatal("TryLock should fail while RLocker is locked")
+	}
+	l.Unlock()
+	if !rw.TryLock() {
+		t.Fatal("expect RLocker to release the read lock")
+	}
+	rw.Unlock()
+}
+
+// BenchmarkReaders 1到64个reader并发读，比较sync.RWMutex和ShardedRWMutex。
+// 读的临界区很短，sync.RWMutex的readerCount在CPU之间来回传递，CPU越多差距越大；
+// 只有一个CPU时两者差不多，ShardedRWMutex还要多付出取goroutine id的开销。
+func BenchmarkReaders(b *testing.B) {
+	for _, readers := range []int{1, 2, 4, 8, 16, 32, 64} {
+		for _, impl := range []struct {
+			name string
+			read func()
+		}{
+			{"sync.RWMutex", func() func() {
+				var rw sync.RWMutex
+				return func() {
+					rw.RLock()
+					rw.RUnlock()
+				}
+			}()},
+			{"sharded", func() func() {
+				rw := NewSharded(0)
+				return func() {
+					rw.RLock()
+					rw.RUnlock()
+				}
+			}()},
+		} {
+			b.Run(fmt.Sprintf("readers=%d/%s", readers, impl.name), func(b *testing.B) {
+				var wg sync.WaitGroup
+				per := b.N/readers + 1
+				b.ResetTimer()
+				for i := 0; i < readers; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for j := 0; j < per; j++ {
+							impl.read()
+						}
+					}()
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
+
+// BenchmarkWrite 写的代价，ShardedRWMutex要锁住所有分片
+func BenchmarkWrite(b *testing.B) {
+	b.Run("sync.RWMutex", func(b *testing.B) {
+		var rw sync.RWMutex
+		for i := 0; i < b.N; i++ {
+			rw.Lock()
+			rw.Unlock()
+		}
+	})
+	b.Run("sharded", func(b *testing.B) {
+		rw := NewSharded(0)
+		for i := 0; i < b.N; i++ {
+			rw.Lock()
+			rw.Unlock()
+		}
+	})
+}
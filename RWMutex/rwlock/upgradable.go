@@ -0,0 +1,370 @@
+package rwlock
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/petermattis/goid"
+)
+
+// UpgradableRWMutex 支持可升级读锁的读写锁。
+//
+// 用sync.RWMutex的时候，读的过程中发现需要写，只能先RUnlock再Lock，
+// 中间可能有别的writer进来修改了数据，所以拿到写锁之后还得重新检查一遍。
+// UpgradableRWMutex多了一种可升级的读锁（ULock）：同一时刻只有一个持有者，
+// 可以和普通的reader共存，但是和writer互斥，所以它调用Upgrade升级为写锁时，
+// 中间不会有别的writer插进来，读到的数据仍然有效。持有写锁时可以调用Downgrade
+// 降级为读锁，同样不会有writer插进来。
+//
+// 加锁和解锁需要在同一个goroutine中进行，会导致死锁的调用直接panic，例如
+// 持有读锁时调用Lock、Upgrade，或者重复ULock。
+// 有writer在等待时新来的reader要排队，和sync.RWMutex一样是写优先的。
+type UpgradableRWMutex struct {
+	mu       sync.Mutex
+	readers  map[int64]int // 持有读锁的goroutine和重入的次数
+	nreaders int           // 持有读锁的次数之和
+	upgrader int64         // 持有可升级读锁的goroutine，0表示没有
+	writer   int64         // 持有写锁的goroutine，0表示没有
+
+	upgrading bool          // upgrader在等待reader释放，等待期间新来的reader要排队
+	upgradeCh chan struct{} // 升级完成时关闭
+
+	rwait   []int64       // 等待的reader
+	rbatch  chan struct{} // 等待的reader在上面阻塞，关闭时它们一起获得读锁
+	queue   list.List     // 等待的writer和upgrader，先入先出，*uwaiter
+	nwriter int           // queue中writer的数量
+}
+
+type uwaiter struct {
+	gid   int64
+	write bool          // true是writer，false是upgrader
+	ch    chan struct{} // 获得锁时关闭
+}
+
+func (rw *UpgradableRWMutex) panicf(format string, args ...interface{}) {
+	rw.mu.Unlock()
+	panic(fmt.Sprintf("rwlock: "+format, args...))
+}
+
+// canRead 新来的reader能否直接获得读锁，需要持有mu
+func (rw *UpgradableRWMutex) canRead() bool {
+	return rw.writer == 0 && !rw.upgrading && rw.nwriter == 0
+}
+
+// RLock 请求读锁。已经持有读锁或者可升级读锁的goroutine直接进入，
+// 否则排在等待的writer后面会死锁。
+func (rw *UpgradableRWMutex) RLock() {
+	rw.RLockContext(context.Background())
+}
+
+// tryRLock 检查死锁并尝试获取读锁，需要持有mu
+func (rw *UpgradableRWMutex) tryRLock(me int64) bool {
+	if rw.writer == me {
+		rw.panicf("RLock while holding write lock would deadlock, use Downgrade")
+	}
+	if rw.canRead() || rw.readers[me] > 0 || rw.upgrader == me {
+		rw.addReader(me)
+		return true
+	}
+	return false
+}
+
+// TryRLock 尝试获取读锁，不会阻塞
+func (rw *UpgradableRWMutex) TryRLock() bool {
+	me := goid.Get()
+	rw.mu.Lock()
+	ok := rw.tryRLock(me)
+	rw.mu.Unlock()
+	return ok
+}
+
+// RLockContext 请求读锁，ctx被取消时返回ctx.Err()
+func (rw *UpgradableRWMutex) RLockContext(ctx context.Context) error {
+	me := goid.Get()
+	rw.mu.Lock()
+	if rw.tryRLock(me) {
+		rw.mu.Unlock()
+		return nil
+	}
+	if rw.rbatch == nil {
+		rw.rbatch = make(chan struct{})
+	}
+	ch := rw.rbatch
+	rw.rwait = append(rw.rwait, me)
+	rw.mu.Unlock()
+
+	select {
+	case <-ch: // 被唤醒时已经计入readers
+		return nil
+	case <-ctx.Done():
+	}
+
+	rw.mu.Lock()
+	select {
+	case <-ch: // 取消的同时已经获得了读锁，只能再释放掉
+		rw.mu.Unlock()
+		rw.RUnlock()
+	default:
+		for i, gid := range rw.rwait {
+			if gid == me {
+				rw.rwait = append(rw.rwait[:i], rw.rwait[i+1:]...)
+				break
+			}
+		}
+		rw.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+// addReader 需要持有mu
+func (rw *UpgradableRWMutex) addReader(gid int64) {
+	if rw.readers == nil {
+		rw.readers = make(map[int64]int)
+	}
+	rw.readers[gid]++
+	rw.nreaders++
+}
+
+// RUnlock 释放读锁
+func (rw *UpgradableRWMutex) RUnlock() {
+	me := goid.Get()
+	rw.mu.Lock()
+	n := rw.readers[me]
+	if n == 0 {
+		rw.panicf("RUnlock of unlocked read lock by goroutine %d", me)
+	}
+	if n == 1 {
+		delete(rw.readers, me)
+	} else {
+		rw.readers[me] = n - 1
+	}
+	rw.nreaders--
+	if rw.nreaders == 0 {
+		rw.dispatch()
+	}
+	rw.mu.Unlock()
+}
+
+// ULock 请求可升级读锁
+func (rw *UpgradableRWMutex) ULock() {
+	rw.ULockContext(context.Background())
+}
+
+// TryULock 尝试获取可升级读锁，不会阻塞
+func (rw *UpgradableRWMutex) TryULock() bool {
+	me := goid.Get()
+	rw.mu.Lock()
+	ok := rw.tryULock(me)
+	rw.mu.Unlock()
+	return ok
+}
+
+// ULockContext 请求可升级读锁，ctx被取消时返回ctx.Err()
+func (rw *UpgradableRWMutex) ULockContext(ctx context.Context) error {
+	me := goid.Get()
+	rw.mu.Lock()
+	if rw.tryULock(me) {
+		rw.mu.Unlock()
+		return nil
+	}
+	return rw.wait(ctx, &uwaiter{gid: me})
+}
+
+// tryULock 检查死锁并尝试获取可升级读锁，需要持有mu
+func (rw *UpgradableRWMutex) tryULock(me int64) bool {
+	switch {
+	case rw.upgrader == me:
+		rw.panicf("recursive ULock would deadlock")
+	case rw.writer == me:
+		rw.panicf("ULock while holding write lock would deadlock")
+	case rw.readers[me] > 0:
+		rw.panicf("ULock while holding read lock would deadlock")
+	}
+	if rw.writer == 0 && rw.upgrader == 0 && rw.queue.Len() == 0 {
+		rw.upgrader = me
+		return true
+	}
+	return false
+}
+
+// UUnlock 释放可升级读锁
+func (rw *UpgradableRWMutex) UUnlock() {
+	me := goid.Get()
+	rw.mu.Lock()
+	if rw.upgrader != me {
+		rw.panicf("UUnlock of unlocked upgradable lock by goroutine %d", me)
+	}
+	rw.upgrader = 0
+	rw.dispatch()
+	rw.mu.Unlock()
+}
+
+// Upgrade 把持有的可升级读锁升级为写锁，等到其它reader都释放。
+// 期间不会有别的writer获得锁，升级之后用Unlock释放，或者Downgrade降级为读锁。
+func (rw *UpgradableRWMutex) Upgrade() {
+	me := goid.Get()
+	rw.mu.Lock()
+	if rw.upgrader != me {
+		rw.panicf("Upgrade without holding upgradable lock by goroutine %d", me)
+	}
+	if rw.readers[me] > 0 {
+		rw.panicf("Upgrade while holding read lock would deadlock")
+	}
+	if rw.nreaders == 0 {
+		rw.upgrader = 0
+		rw.writer = me
+		rw.mu.Unlock()
+		return
+	}
+	rw.upgrading = true
+	ch := make(chan struct{})
+	rw.upgradeCh = ch
+	rw.mu.Unlock()
+	<-ch // 最后一个reader释放时把写锁交给我们
+}
+
+// Lock 请求写锁
+func (rw *UpgradableRWMutex) Lock() {
+	rw.LockContext(context.Background())
+}
+
+// TryLock 尝试获取写锁，不会阻塞
+func (rw *UpgradableRWMutex) TryLock() bool {
+	me := goid.Get()
+	rw.mu.Lock()
+	ok := rw.tryLock(me)
+	rw.mu.Unlock()
+	return ok
+}
+
+// LockContext 请求写锁，ctx被取消时返回ctx.Err()。
+// 放弃等待的writer不会再占着位置，排在它后面的reader会立即被放行。
+func (rw *UpgradableRWMutex) LockContext(ctx context.Context) error {
+	me := goid.Get()
+	rw.mu.Lock()
+	if rw.tryLock(me) {
+		rw.mu.Unlock()
+		return nil
+	}
+	rw.nwriter++
+	return rw.wait(ctx, &uwaiter{gid: me, write: true})
+}
+
+// tryLock 检查死锁并尝试获取写锁，需要持有mu
+func (rw *UpgradableRWMutex) tryLock(me int64) bool {
+	switch {
+	case rw.writer == me:
+		rw.panicf("recursive Lock would deadlock")
+	case rw.upgrader == me:
+		rw.panicf("Lock while holding upgradable lock would deadlock, use Upgrade")
+	case rw.readers[me] > 0:
+		rw.panicf("Lock while holding read lock would deadlock")
+	}
+	if rw.writer == 0 && rw.upgrader == 0 && rw.nreaders == 0 && rw.queue.Len() == 0 {
+		rw.writer = me
+		return true
+	}
+	return false
+}
+
+// wait 把waiter排到队尾，等到获得锁或者ctx被取消。调用时持有mu，返回时已经释放。
+func (rw *UpgradableRWMutex) wait(ctx context.Context, w *uwaiter) error {
+	w.ch = make(chan struct{})
+	e := rw.queue.PushBack(w)
+	rw.mu.Unlock()
+
+	select {
+	case <-w.ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	rw.mu.Lock()
+	select {
+	case <-w.ch: // 取消的同时已经获得了锁，只能再释放掉
+		if w.write {
+			rw.writer = 0
+		} else {
+			rw.upgrader = 0
+		}
+	default:
+		rw.queue.Remove(e)
+		if w.write {
+			rw.nwriter--
+		}
+	}
+	rw.dispatch() // 因为这个waiter而阻塞的reader和waiter可以进入了
+	rw.mu.Unlock()
+	return ctx.Err()
+}
+
+// Unlock 释放写锁
+func (rw *UpgradableRWMutex) Unlock() {
+	me := goid.Get()
+	rw.mu.Lock()
+	if rw.writer != me {
+		rw.panicf("Unlock of unlocked write lock by goroutine %d", me)
+	}
+	rw.writer = 0
+	rw.dispatch()
+	rw.mu.Unlock()
+}
+
+// Downgrade 把持有的写锁降级为读锁，期间不会有别的writer获得锁，之后用RUnlock释放
+func (rw *UpgradableRWMutex) Downgrade() {
+	me := goid.Get()
+	rw.mu.Lock()
+	if rw.writer != me {
+		rw.panicf("Downgrade without holding write lock by goroutine %d", me)
+	}
+	rw.writer = 0
+	rw.addReader(me)
+	rw.dispatch()
+	rw.mu.Unlock()
+}
+
+// dispatch 锁的状态变化之后，把锁交给可以进入的waiter，需要持有mu
+func (rw *UpgradableRWMutex) dispatch() {
+	if rw.writer != 0 {
+		return
+	}
+	if rw.upgrading { // 升级优先，等reader都释放
+		if rw.nreaders == 0 {
+			rw.writer = rw.upgrader
+			rw.upgrader = 0
+			rw.upgrading = false
+			close(rw.upgradeCh)
+			rw.upgradeCh = nil
+		}
+		return
+	}
+	for e := rw.queue.Front(); e != nil; e = rw.queue.Front() {
+		w := e.Value.(*uwaiter)
+		if w.write {
+			if rw.upgrader != 0 || rw.nreaders != 0 {
+				break
+			}
+			rw.queue.Remove(e)
+			rw.nwriter--
+			rw.writer = w.gid
+			close(w.ch)
+			return
+		}
+		if rw.upgrader != 0 {
+			break
+		}
+		rw.queue.Remove(e)
+		rw.upgrader = w.gid
+		close(w.ch)
+	}
+	if rw.canRead() && len(rw.rwait) > 0 {
+		for _, gid := range rw.rwait {
+			rw.addReader(gid)
+		}
+		rw.rwait = rw.rwait[:0]
+		close(rw.rbatch)
+		rw.rbatch = nil
+	}
+}
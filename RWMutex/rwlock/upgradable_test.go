@@ -0,0 +1,262 @@
+package rwlock
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueue 等到有n个writer或者upgrader在排队
+func waitQueue(rw *UpgradableRWMutex, n int) {
+	for {
+		rw.mu.Lock()
+		ok := rw.queue.Len() == n
+		rw.mu.Unlock()
+		if ok {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestUpgradableCounter(t *testing.T) {
+	var rw UpgradableRWMutex
+	var count int
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				rw.Lock()
+				count++
+				rw.Unlock()
+			}
+		}()
+		go func() { // 读到偶数时才加1，检查和修改之间不会有writer插进来
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				rw.ULock()
+				if count%2 == 0 {
+					rw.Upgrade()
+					count++
+					rw.Downgrade()
+					rw.RUnlock()
+				} else {
+					rw.UUnlock()
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				rw.RLock()
+				_ = count
+				rw.RUnlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if count < 5000 || count > 10000 {
+		t.Fatalf("unexpected count %d", count)
+	}
+}
+
+// 可升级读锁和普通的reader共存，升级时等待reader释放，期间等待的writer进不来
+func TestUpgradeExcludesWriters(t *testing.T) {
+	var rw UpgradableRWMutex
+	rw.ULock()
+	readerIn := make(chan struct{})
+	readerOut := make(chan struct{})
+	go func() {
+		rw.RLock()
+		close(readerIn)
+		<-readerOut
+		rw.RUnlock()
+	}()
+	<-readerIn
+
+	var order []string
+	done := make(chan struct{})
+	go func() {
+		rw.Lock()
+		order = append(order, "writer")
+		rw.Unlock()
+		close(done)
+	}()
+	waitQueue(&rw, 1)
+
+	go func() {
+		for {
+			rw.mu.Lock()
+			upgrading := rw.upgrading
+			rw.mu.Unlock()
+			if upgrading {
+				break
+			}
+			runtime.Gosched()
+		}
+		close(readerOut)
+	}()
+	rw.Upgrade()
+	order = append(order, "upgrader")
+	rw.Unlock()
+	<-done
+	if order[0] != "upgrader" || order[1] != "writer" {
+		t.Fatalf("expect upgrader before writer but got %v", order)
+	}
+}
+
+// 降级之后等待的reader可以进入，等待的writer要等读锁释放
+func TestDowngrade(t *testing.T) {
+	var rw UpgradableRWMutex
+	rw.Lock()
+	readerDone := make(chan struct{})
+	go func() {
+		rw.RLock()
+		rw.RUnlock()
+		close(readerDone)
+	}()
+	for {
+		rw.mu.Lock()
+		n := len(rw.rwait)
+		rw.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		runtime.Gosched()
+	}
+	rw.Downgrade()
+	<-readerDone
+
+	writerDone := make(chan struct{})
+	go func() {
+		rw.Lock()
+		rw.Unlock()
+		close(writerDone)
+	}()
+	waitQueue(&rw, 1)
+	select {
+	case <-writerDone:
+		t.Fatal("writer got the lock while downgraded read lock is held")
+	default:
+	}
+	rw.RUnlock()
+	<-writerDone
+}
+
+func TestUpgradableDeadlockChecks(t *testing.T) {
+	cases := []struct {
+		name string
+		fn   func(rw *UpgradableRWMutex)
+		want string
+	}{
+		{"lock-while-read", func(rw *UpgradableRWMutex) { rw.RLock(); rw.Lock() }, "would deadlock"},
+		{"lock-while-upgradable", func(rw *UpgradableRWMutex) { rw.ULock(); rw.Lock() }, "use Upgrade"},
+		{"recursive-ulock", func(rw *UpgradableRWMutex) { rw.ULock(); rw.ULock() }, "would deadlock"},
+		{"recursive-lock", func(rw *UpgradableRWMutex) { rw.Lock(); rw.Lock() }, "would deadlock"},
+		{"upgrade-while-read", func(rw *UpgradableRWMutex) { rw.ULock(); rw.RLock(); rw.Upgrade() }, "would deadlock"},
+		{"upgrade-without-ulock", func(rw *UpgradableRWMutex) { rw.Upgrade() }, "without holding upgradable"},
+		{"downgrade-without-lock", func(rw *UpgradableRWMutex) { rw.Downgrade() }, "without holding write"},
+		{"unlock-of-unlocked", func(rw *UpgradableRWMutex) { rw.Unlock() }, "unlocked"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if s, _ := r.(string); !strings.Contains(s, c.want) {
+					t.Fatalf("expect panic containing %q but got %v", c.want, r)
+				}
+			}()
+			c.fn(&UpgradableRWMutex{})
+		})
+	}
+}
+
+func TestUpgradableTryLock(t *testing.T) {
+	var rw UpgradableRWMutex
+	if !rw.TryULock() {
+		t.Fatal("TryULock on unlocked mutex should succeed")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if rw.TryULock() || rw.TryLock() {
+			t.Error("TryULock/TryLock should fail while upgradable lock is held")
+		}
+		if !rw.TryRLock() {
+			t.Error("TryRLock should coexist with upgradable lock")
+			return
+		}
+		rw.RUnlock()
+	}()
+	<-done
+	rw.UUnlock()
+	if !rw.TryLock() {
+		t.Fatal("TryLock on unlocked mutex should succeed")
+	}
+	rw.Unlock()
+}
+
+// writer放弃等待时，排在它后面的reader和upgrader立即被放行
+func TestUpgradableCancelledWriter(t *testing.T) {
+	var rw UpgradableRWMutex
+	held := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		rw.RLock()
+		close(held)
+		<-release
+		rw.RUnlock()
+	}()
+	<-held
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- rw.LockContext(ctx) }()
+	waitQueue(&rw, 1)
+
+	readerDone := make(chan struct{})
+	go func() {
+		rw.RLock()
+		rw.RUnlock()
+		close(readerDone)
+	}()
+	upgraderDone := make(chan struct{})
+	go func() {
+		rw.ULock()
+		rw.UUnlock()
+		close(upgraderDone)
+	}()
+	waitQueue(&rw, 2)
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("expect Canceled but got %v", err)
+	}
+	for _, ch := range []chan struct{}{readerDone, upgraderDone} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("waiter still blocked after the writer gave up")
+		}
+	}
+	close(release)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rw.Lock()
+	if err := <-async(func() error { return rw.ULockContext(ctx) }); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded but got %v", err)
+	}
+	rw.Unlock()
+}
+
+func async(fn func() error) <-chan error {
+	errc := make(chan error, 1)
+	go func() { errc <- fn() }()
+	return errc
+}
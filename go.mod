@@ -1,6 +1,6 @@
 module GoConcurrentProgramming
 
-go 1.13
+go 1.18
 
 require (
 	github.com/elliotchance/orderedmap v1.3.0